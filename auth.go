@@ -0,0 +1,130 @@
+package goreq
+
+import (
+	"net/http"
+)
+
+// Authenticator adds credentials to a request. It is called every time a
+// request is sent, including retries and redirects to the same host, and is
+// free to modify req.
+type Authenticator interface {
+	Apply(req *http.Request) error
+}
+
+// Refresher is implemented by an Authenticator able to renew its credentials
+// when a request is rejected with a 401 response. Refresh returns whether the
+// request should be authenticated and sent again, which is done once and only
+// when the request body can be replayed.
+type Refresher interface {
+	Refresh(res *http.Response) (bool, error)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+// BasicAuth authenticates requests with the Basic scheme.
+func BasicAuth(username string, password string) *basicAuth {
+	return &basicAuth{username: username, password: password}
+}
+
+func (a *basicAuth) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+type bearerAuth struct {
+	token string
+}
+
+// Bearer authenticates requests with the given bearer token.
+func Bearer(token string) *bearerAuth {
+	return &bearerAuth{token: token}
+}
+
+func (a *bearerAuth) Apply(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+type apiKeyHeader struct {
+	name string
+	key  string
+}
+
+// APIKeyHeader authenticates requests with an API key sent in the header
+// name.
+func APIKeyHeader(name string, key string) *apiKeyHeader {
+	return &apiKeyHeader{name: name, key: key}
+}
+
+func (a *apiKeyHeader) Apply(req *http.Request) error {
+	req.Header.Set(a.name, a.key)
+	return nil
+}
+
+type apiKeyQuery struct {
+	name string
+	key  string
+}
+
+// APIKeyQuery authenticates requests with an API key sent in the query string
+// parameter name.
+func APIKeyQuery(name string, key string) *apiKeyQuery {
+	return &apiKeyQuery{name: name, key: key}
+}
+
+func (a *apiKeyQuery) Apply(req *http.Request) error {
+	query := req.URL.Query()
+	query.Set(a.name, a.key)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// authenticate returns a middleware applying auth to the requests sent to
+// host. Credentials are never sent to other hosts the request is redirected
+// to.
+func authenticate(auth Authenticator, host string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		send := func(req *http.Request) (*http.Response, error) {
+			// a RoundTripper must not modify the request it is given
+			req = req.Clone(req.Context())
+			if err := auth.Apply(req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+
+		return func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host != host {
+				return next(req)
+			}
+
+			res, err := send(req)
+			if err != nil || res.StatusCode != http.StatusUnauthorized {
+				return res, err
+			}
+			refresher, ok := auth.(Refresher)
+			if !ok || !rewindable(req) {
+				return res, nil
+			}
+
+			retry, err := refresher.Refresh(res)
+			if err != nil {
+				drain(res)
+				return nil, err
+			}
+			if !retry {
+				return res, nil
+			}
+			drain(res)
+
+			req, err = rewind(req)
+			if err != nil {
+				return nil, err
+			}
+			return send(req)
+		}
+	}
+}
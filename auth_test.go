@@ -0,0 +1,193 @@
+package goreq
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+type refreshingAuth struct {
+	mu        sync.Mutex
+	token     string
+	refreshes int
+}
+
+func (a *refreshingAuth) Apply(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *refreshingAuth) Refresh(res *http.Response) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refreshes++
+	a.token = "fresh"
+	return true, nil
+}
+
+type failingAuth struct{}
+
+func (failingAuth) Apply(req *http.Request) error {
+	return errors.New("no credentials")
+}
+
+func TestAuth(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Authenticator", func() {
+		var ts, other *httptest.Server
+		var attempts int
+
+		g.Before(func() {
+			other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "other:%s", r.Header.Get("Authorization"))
+			}))
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				switch r.URL.Path {
+				case "/protected":
+					if r.Header.Get("Authorization") != "Bearer fresh" {
+						w.WriteHeader(401)
+						return
+					}
+					b, _ := ioutil.ReadAll(r.Body)
+					fmt.Fprintf(w, "%s", b)
+				case "/away":
+					http.Redirect(w, r, other.URL, http.StatusFound)
+				case "/here":
+					http.Redirect(w, r, "/", http.StatusFound)
+				default:
+					fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"), r.URL.RawQuery)
+				}
+			}))
+		})
+
+		g.BeforeEach(func() {
+			attempts = 0
+		})
+
+		g.After(func() {
+			ts.Close()
+			other.Close()
+		})
+
+		g.It("Should send a bearer token", func() {
+			res, err := Request{Uri: ts.URL, Auth: Bearer("token")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("Bearer token||"))
+		})
+
+		g.It("Should send basic credentials", func() {
+			res, err := Request{Uri: ts.URL, Auth: BasicAuth("user", "pass")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("Basic dXNlcjpwYXNz||"))
+		})
+
+		g.It("Should send an API key in a header", func() {
+			res, err := Request{Uri: ts.URL, Auth: APIKeyHeader("X-Api-Key", "secret")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("|secret|"))
+		})
+
+		g.It("Should send an API key in the query string", func() {
+			res, err := Request{Uri: ts.URL + "?foo=bar", Auth: APIKeyQuery("api_key", "secret")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("||api_key=secret&foo=bar"))
+		})
+
+		g.It("Should use the client authenticator unless the request sets one", func() {
+			c := NewClient(WithAuth(Bearer("client")))
+
+			res, _ := c.Do(Request{Uri: ts.URL})
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("Bearer client||"))
+
+			res, _ = c.Do(Request{Uri: ts.URL, Auth: Bearer("request")})
+			str, _ = res.Body.ToString()
+			Expect(str).Should(Equal("Bearer request||"))
+		})
+
+		g.It("Should authenticate after the middleware", func() {
+			var seen string
+			peek := func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					seen = req.Header.Get("Authorization")
+					return next(req)
+				}
+			}
+
+			_, err := Request{Uri: ts.URL, Auth: Bearer("token"), Middleware: []Middleware{peek}}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(seen).Should(Equal(""))
+		})
+
+		g.It("Should refresh the credentials on 401 and replay the body", func() {
+			auth := &refreshingAuth{token: "stale"}
+
+			res, err := Request{Method: "POST", Uri: ts.URL + "/protected", Body: "foo", Auth: auth}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("foo"))
+			Expect(auth.refreshes).Should(Equal(1))
+			Expect(attempts).Should(Equal(2))
+		})
+
+		g.It("Should not refresh when the body can't be replayed", func() {
+			auth := &refreshingAuth{token: "stale"}
+			body := ioutil.NopCloser(strings.NewReader("foo"))
+
+			res, err := Request{Method: "POST", Uri: ts.URL + "/protected", Body: body, Auth: auth}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(401))
+			Expect(auth.refreshes).Should(Equal(0))
+		})
+
+		g.It("Should authenticate redirects to the same host", func() {
+			res, err := Request{Uri: ts.URL + "/here", MaxRedirects: 1, Auth: Bearer("token")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("Bearer token||"))
+		})
+
+		g.It("Should not send credentials to other hosts", func() {
+			res, err := Request{Uri: ts.URL + "/away", MaxRedirects: 1, Auth: Bearer("token")}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("other:"))
+		})
+
+		g.It("Should return authenticator errors", func() {
+			res, err := Request{Uri: ts.URL, Auth: failingAuth{}}.Do()
+
+			Expect(res).Should(BeNil())
+			Expect(err.Error()).Should(ContainSubstring("no credentials"))
+			Expect(attempts).Should(Equal(0))
+		})
+	})
+}
@@ -0,0 +1,303 @@
+package goreq
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit tracked by a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through while counting their failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests until the cool-down is over.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through to decide whether
+	// to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOpenError is returned, wrapped in an *Error, for requests rejected
+// because their circuit is open.
+type CircuitOpenError struct {
+	// Key is the key of the open circuit, the request host by default.
+	Key string
+	// State is the state of the circuit, CircuitOpen or CircuitHalfOpen
+	// when all of its trial requests are already in flight.
+	State CircuitState
+	// RetryAfter is the time left before the circuit lets trial requests
+	// through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is %s for %s", e.State, e.Key)
+}
+
+// CircuitBreaker stops sending requests to a failing downstream for a while,
+// failing them at once with a *CircuitOpenError instead.
+//
+// Every key, the request host by default, has its own circuit. A closed
+// circuit opens once ConsecutiveFailures requests fail in a row, or once the
+// ratio of failed requests reaches FailureRatio. After CoolDown, the circuit
+// becomes half-open and lets HalfOpenRequests trial requests through: it
+// closes again if they all succeed and opens again as soon as one fails.
+//
+// A CircuitBreaker is used through its Middleware, or set on a Client with
+// WithCircuitBreaker, and must not be copied after first use.
+type CircuitBreaker struct {
+	// Key returns the key of the circuit tracking req. The host of the
+	// request is used if nil.
+	Key func(req *http.Request) string
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row. Zero disables it.
+	ConsecutiveFailures int
+	// FailureRatio opens the circuit when the ratio of failed requests,
+	// between 0 and 1, reaches it. Zero disables it.
+	FailureRatio float64
+	// MinRequests is the number of requests needed before FailureRatio is
+	// considered.
+	MinRequests int
+	// Window is how often the counts of a closed circuit are reset. They are
+	// only reset when the circuit changes state if zero.
+	Window time.Duration
+	// CoolDown is how long the circuit stays open.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of trial requests which must succeed to
+	// close a half-open circuit. One is used if zero.
+	HalfOpenRequests int
+	// IsFailure tells whether a request failed. Errors and 5xx responses are
+	// failures if nil. Requests whose context is done are never counted.
+	IsFailure func(res *http.Response, err error) bool
+	// OnStateChange is called every time a circuit changes state.
+	OnStateChange func(key string, from CircuitState, to CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+// DefaultCircuitBreaker returns a circuit breaker opening a host's circuit
+// after 5 consecutive failures, or when half of at least 20 requests in a
+// minute fail, for 30 seconds.
+func DefaultCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		Window:              time.Minute,
+		CoolDown:            30 * time.Second,
+		HalfOpenRequests:    1,
+	}
+}
+
+type circuit struct {
+	state CircuitState
+	// generation changes with the state, so that requests sent in a previous
+	// state aren't counted in the new one.
+	generation  uint64
+	since       time.Time
+	requests    int
+	failures    int
+	consecutive int
+	// inFlight is the number of trial requests of a half-open circuit.
+	inFlight  int
+	successes int
+}
+
+type stateChange struct {
+	key      string
+	from, to CircuitState
+}
+
+// State returns the state of the circuit of key.
+func (b *CircuitBreaker) State(key string) CircuitState {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return CircuitClosed
+	}
+	b.refresh(key, c, &changes)
+	return c.state
+}
+
+// Middleware returns the middleware applying the circuit breaker.
+func (b *CircuitBreaker) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			key := req.URL.Host
+			if b.Key != nil {
+				key = b.Key(req)
+			}
+
+			generation, err := b.allow(key)
+			if err != nil {
+				return nil, err
+			}
+			res, err := next(req)
+			if req.Context().Err() != nil {
+				b.done(key, generation, nil)
+				return res, err
+			}
+			failed := b.isFailure(res, err)
+			b.done(key, generation, &failed)
+			return res, err
+		}
+	}
+}
+
+func (b *CircuitBreaker) isFailure(res *http.Response, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(res, err)
+	}
+	return err != nil || res.StatusCode >= 500
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// allow returns the generation of the circuit of key if a request may be
+// sent, or a *CircuitOpenError.
+func (b *CircuitBreaker) allow(key string) (uint64, error) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{since: b.clock()}
+		b.circuits[key] = c
+	}
+	b.refresh(key, c, &changes)
+
+	switch c.state {
+	case CircuitOpen:
+		return 0, &CircuitOpenError{Key: key, State: CircuitOpen, RetryAfter: c.since.Add(b.CoolDown).Sub(b.clock())}
+	case CircuitHalfOpen:
+		if c.inFlight >= b.halfOpenRequests() {
+			return 0, &CircuitOpenError{Key: key, State: CircuitHalfOpen}
+		}
+		c.inFlight++
+	}
+	return c.generation, nil
+}
+
+// done records the outcome of a request sent in generation. failed is nil
+// when the outcome tells nothing about the downstream, such as when the
+// request was cancelled.
+func (b *CircuitBreaker) done(key string, generation uint64, failed *bool) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[key]
+	b.refresh(key, c, &changes)
+	if c.generation != generation {
+		return
+	}
+
+	if c.state == CircuitHalfOpen {
+		c.inFlight--
+		switch {
+		case failed == nil:
+		case *failed:
+			b.setState(key, c, CircuitOpen, &changes)
+		default:
+			c.successes++
+			if c.successes >= b.halfOpenRequests() {
+				b.setState(key, c, CircuitClosed, &changes)
+			}
+		}
+		return
+	}
+
+	if failed == nil {
+		return
+	}
+	c.requests++
+	if !*failed {
+		c.consecutive = 0
+		return
+	}
+	c.failures++
+	c.consecutive++
+	if b.ConsecutiveFailures > 0 && c.consecutive >= b.ConsecutiveFailures ||
+		b.FailureRatio > 0 && c.requests >= b.MinRequests && float64(c.failures)/float64(c.requests) >= b.FailureRatio {
+		b.setState(key, c, CircuitOpen, &changes)
+	}
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests > 0 {
+		return b.HalfOpenRequests
+	}
+	return 1
+}
+
+// refresh moves an open circuit whose cool-down is over to half-open, and
+// resets the counts of a closed circuit when its window is over.
+func (b *CircuitBreaker) refresh(key string, c *circuit, changes *[]stateChange) {
+	now := b.clock()
+	switch c.state {
+	case CircuitOpen:
+		if !now.Before(c.since.Add(b.CoolDown)) {
+			b.setState(key, c, CircuitHalfOpen, changes)
+		}
+	case CircuitClosed:
+		if b.Window > 0 && !now.Before(c.since.Add(b.Window)) {
+			b.reset(c, now)
+		}
+	}
+}
+
+func (b *CircuitBreaker) setState(key string, c *circuit, state CircuitState, changes *[]stateChange) {
+	*changes = append(*changes, stateChange{key: key, from: c.state, to: state})
+	c.state = state
+	b.reset(c, b.clock())
+}
+
+func (b *CircuitBreaker) reset(c *circuit, now time.Time) {
+	c.generation++
+	c.since = now
+	c.requests, c.failures, c.consecutive = 0, 0, 0
+	c.inFlight, c.successes = 0, 0
+}
+
+// notify calls OnStateChange, outside of the lock so that the callback may use
+// the circuit breaker.
+func (b *CircuitBreaker) notify(changes []stateChange) {
+	if b.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.OnStateChange(change.key, change.from, change.to)
+	}
+}
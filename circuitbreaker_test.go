@@ -0,0 +1,229 @@
+package goreq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("CircuitBreaker", func() {
+		var ts *httptest.Server
+		var mu sync.Mutex
+		var hits int
+		var now time.Time
+		var host string
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits++
+				mu.Unlock()
+				if strings.HasPrefix(r.URL.Path, "/fail") {
+					w.WriteHeader(503)
+					return
+				}
+				if r.URL.Path == "/slow" {
+					time.Sleep(100 * time.Millisecond)
+				}
+				w.WriteHeader(200)
+			}))
+			host = strings.TrimPrefix(ts.URL, "http://")
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			hits = 0
+			mu.Unlock()
+			now = time.Unix(0, 0)
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		newBreaker := func(b *CircuitBreaker) *CircuitBreaker {
+			b.now = func() time.Time { return now }
+			return b
+		}
+
+		send := func(client *Client, path string) (*Response, error) {
+			res, err := client.Do(Request{Uri: ts.URL + path})
+			if res != nil {
+				res.Body.Close()
+			}
+			return res, err
+		}
+
+		sent := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return hits
+		}
+
+		g.It("Should open after consecutive failures", func() {
+			var changes []string
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 2, CoolDown: time.Minute})
+			b.OnStateChange = func(key string, from, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%s:%s>%s", key, from, to))
+			}
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			send(client, "/ok")
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitOpen))
+			Expect(changes).Should(Equal([]string{host + ":closed>open"}))
+
+			res, err := send(client, "/ok")
+			Expect(res).Should(BeNil())
+			var circuitOpen *CircuitOpenError
+			Expect(errors.As(err, &circuitOpen)).Should(BeTrue())
+			Expect(circuitOpen.Key).Should(Equal(host))
+			Expect(circuitOpen.State).Should(Equal(CircuitOpen))
+			Expect(circuitOpen.RetryAfter).Should(Equal(time.Minute))
+			Expect(sent()).Should(Equal(4))
+		})
+
+		g.It("Should open when the failure ratio is reached", func() {
+			b := newBreaker(&CircuitBreaker{FailureRatio: 0.5, MinRequests: 4, CoolDown: time.Minute})
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+			send(client, "/ok")
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitOpen))
+		})
+
+		g.It("Should reset the counts of every window", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 2, Window: time.Minute, CoolDown: time.Minute})
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			now = now.Add(time.Minute)
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+		})
+
+		g.It("Should close a half-open circuit after successful trials", func() {
+			var changes []CircuitState
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute, HalfOpenRequests: 2})
+			b.OnStateChange = func(key string, from, to CircuitState) {
+				changes = append(changes, to)
+			}
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			now = now.Add(59 * time.Second)
+			Expect(b.State(host)).Should(Equal(CircuitOpen))
+			now = now.Add(time.Second)
+			Expect(b.State(host)).Should(Equal(CircuitHalfOpen))
+
+			_, err := send(client, "/ok")
+			Expect(err).Should(BeNil())
+			Expect(b.State(host)).Should(Equal(CircuitHalfOpen))
+			send(client, "/ok")
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+			Expect(changes).Should(Equal([]CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}))
+		})
+
+		g.It("Should open a half-open circuit again on failure", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute, HalfOpenRequests: 2})
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			now = now.Add(time.Minute)
+			send(client, "/ok")
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitOpen))
+		})
+
+		g.It("Should only let the trial requests through when half-open", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			now = now.Add(time.Minute)
+
+			done := make(chan error)
+			go func() {
+				_, err := send(client, "/slow")
+				done <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+
+			_, err := send(client, "/ok")
+			var circuitOpen *CircuitOpenError
+			Expect(errors.As(err, &circuitOpen)).Should(BeTrue())
+			Expect(circuitOpen.State).Should(Equal(CircuitHalfOpen))
+
+			Expect(<-done).Should(BeNil())
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+		})
+
+		g.It("Should track every key on its own", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			b.Key = func(req *http.Request) string {
+				return req.URL.Path
+			}
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			Expect(b.State("/fail")).Should(Equal(CircuitOpen))
+			_, err := send(client, "/ok")
+			Expect(err).Should(BeNil())
+			Expect(b.State("/ok")).Should(Equal(CircuitClosed))
+		})
+
+		g.It("Should use IsFailure", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			b.IsFailure = func(res *http.Response, err error) bool {
+				return err != nil
+			}
+			client := NewClient(WithCircuitBreaker(b))
+
+			send(client, "/fail")
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+		})
+
+		g.It("Should not count cancelled requests", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			client := NewClient(WithCircuitBreaker(b))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := client.Do(Request{Uri: ts.URL + "/slow", Context: ctx})
+
+			Expect(err).ShouldNot(BeNil())
+			Expect(b.State(host)).Should(Equal(CircuitClosed))
+		})
+
+		g.It("Should not retry requests rejected by an open circuit", func() {
+			b := newBreaker(&CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute})
+			client := NewClient(WithCircuitBreaker(b), WithRetryPolicy(DefaultRetryPolicy()))
+
+			_, err := send(client, "/fail")
+
+			var circuitOpen *CircuitOpenError
+			Expect(errors.As(err, &circuitOpen)).Should(BeTrue())
+			Expect(sent()).Should(Equal(1))
+		})
+	})
+}
@@ -0,0 +1,542 @@
+package goreq
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client carries the transport, dialer and request defaults used to send a
+// Request. Different parts of a program can use different clients without
+// sharing connect timeouts, TLS settings or proxies.
+//
+// A Client is built with NewClient. The package level Request.Do uses a
+// default Client backed by DefaultDialer, DefaultTransport and DefaultClient.
+type Client struct {
+	dialer          *net.Dialer
+	connectTimeout  *time.Duration
+	transport       http.RoundTripper
+	client          *http.Client
+	tlsConfig       *tls.Config
+	headers         []headerTuple
+	userAgent       string
+	timeout         time.Duration
+	jar             http.CookieJar
+	maxRedirects    int
+	redirectHeaders bool
+	errorOnStatus   bool
+	rawText         bool
+	retry           *RetryPolicy
+	middleware      []Middleware
+	onAfterResponse func(goreq *Request, res *Response, err error)
+	auth            Authenticator
+
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+	// lru lists the keys of transports, least recently used first.
+	lru []transportKey
+}
+
+// maxTransports is the number of per-request transports a client keeps. The
+// least recently used one is closed when another is needed.
+const maxTransports = 16
+
+// transportKey identifies a transport derived from a client's base transport
+// for a given set of per-request TLS and proxy settings.
+type transportKey struct {
+	base        *http.Transport
+	insecure    bool
+	proxy       string
+	proxyHeader string
+}
+
+// ClientOption configures a Client built by NewClient.
+type ClientOption func(c *Client)
+
+// WithDialer sets the dialer used to open connections. The client uses a copy
+// of it, so later changes to dialer don't apply. It is ignored when a
+// transport with its own Dial function is given through WithTransport.
+func WithDialer(dialer *net.Dialer) ClientOption {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithConnectTimeout sets the connect timeout of the client's dialer, whether
+// it is given before or after WithDialer.
+func WithConnectTimeout(duration time.Duration) ClientOption {
+	return func(c *Client) {
+		c.connectTimeout = &duration
+	}
+}
+
+// WithTransport sets the transport used to send requests. The client uses a
+// clone of it, so transport itself is never modified.
+func WithTransport(transport *http.Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the client's transport.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDefaultHeader adds a header sent with every request unless the request
+// sets a header with the same name.
+func WithDefaultHeader(name string, value string) ClientOption {
+	return func(c *Client) {
+		c.headers = append(c.headers, headerTuple{name: name, value: value})
+	}
+}
+
+// WithUserAgent sets the User-Agent used by requests that don't set one.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithTimeout sets the timeout used by requests that don't set one.
+func WithTimeout(duration time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = duration
+	}
+}
+
+// WithCookieJar sets the cookie jar used by requests that don't set one.
+func WithCookieJar(jar http.CookieJar) ClientOption {
+	return func(c *Client) {
+		c.jar = jar
+	}
+}
+
+// WithMaxRedirects sets the number of redirects followed by requests that
+// don't set MaxRedirects.
+func WithMaxRedirects(maxRedirects int) ClientOption {
+	return func(c *Client) {
+		c.maxRedirects = maxRedirects
+	}
+}
+
+// WithRedirectHeaders makes every request copy its headers when following a
+// redirect.
+func WithRedirectHeaders(redirectHeaders bool) ClientOption {
+	return func(c *Client) {
+		c.redirectHeaders = redirectHeaders
+	}
+}
+
+// WithErrorOnStatus makes every request return a *StatusError for non 2xx
+// responses.
+func WithErrorOnStatus(errorOnStatus bool) ClientOption {
+	return func(c *Client) {
+		c.errorOnStatus = errorOnStatus
+	}
+}
+
+// WithDisableCharsetDecoding makes Body.Text and Body.ToString return the
+// body of every response as received.
+func WithDisableCharsetDecoding(disable bool) ClientOption {
+	return func(c *Client) {
+		c.rawText = disable
+	}
+}
+
+// WithRetryPolicy sets the retry policy used by requests that don't set one.
+func WithRetryPolicy(policy *RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithMiddleware adds middleware run around every request sent by the
+// client. Client middleware runs before, and so wraps, the request's own
+// Middleware.
+func WithMiddleware(middleware ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// WithCircuitBreaker applies breaker to every request sent by the client,
+// before any other middleware.
+func WithCircuitBreaker(breaker *CircuitBreaker) ClientOption {
+	return func(c *Client) {
+		c.middleware = append([]Middleware{breaker.Middleware()}, c.middleware...)
+	}
+}
+
+// WithRateLimiter applies limiter to every request sent by the client, before
+// any other middleware.
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(c *Client) {
+		c.middleware = append([]Middleware{limiter.Middleware()}, c.middleware...)
+	}
+}
+
+// WithOnAfterResponse sets the OnAfterResponse hook of requests that don't
+// set one.
+func WithOnAfterResponse(hook func(goreq *Request, res *Response, err error)) ClientOption {
+	return func(c *Client) {
+		c.onAfterResponse = hook
+	}
+}
+
+// WithAuth sets the Authenticator used by requests that don't set one.
+func WithAuth(auth Authenticator) ClientOption {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// NewClient returns a Client with its own dialer and transport, configured by
+// the given options.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	dialer := &net.Dialer{Timeout: DefaultDialer.Timeout}
+	if c.dialer != nil {
+		d := *c.dialer
+		dialer = &d
+	}
+	if c.connectTimeout != nil {
+		dialer.Timeout = *c.connectTimeout
+	}
+	c.dialer = dialer
+
+	transport, ok := c.transport.(*http.Transport)
+	if ok {
+		transport = transport.Clone()
+	} else {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+	if transport.Dial == nil && transport.DialContext == nil {
+		transport.DialContext = c.dialer.DialContext
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig.Clone()
+	}
+	c.transport = transport
+	c.client = &http.Client{Transport: transport, Jar: c.jar}
+	return c
+}
+
+var defaultClient = &Client{}
+
+func (c *Client) httpClient() *http.Client {
+	if c.client != nil {
+		return c.client
+	}
+	return DefaultClient
+}
+
+func (c *Client) roundTripper() http.RoundTripper {
+	if c.transport != nil {
+		return c.transport
+	}
+	return DefaultTransport
+}
+
+func (c *Client) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.dialer != nil {
+		return c.dialer.DialContext(ctx, network, address)
+	}
+	return DefaultDialer.DialContext(ctx, network, address)
+}
+
+// applyDefaults fills the fields of r left empty with the client's defaults.
+func (c *Client) applyDefaults(r *Request) {
+	r.Method = valueOrDefault(r.Method, "GET")
+	r.UserAgent = valueOrDefault(r.UserAgent, c.userAgent)
+	if r.Timeout == 0 {
+		r.Timeout = c.timeout
+	}
+	if r.MaxRedirects == 0 {
+		r.MaxRedirects = c.maxRedirects
+	}
+	r.RedirectHeaders = r.RedirectHeaders || c.redirectHeaders
+	r.ErrorOnStatus = r.ErrorOnStatus || c.errorOnStatus
+	r.DisableCharsetDecoding = r.DisableCharsetDecoding || c.rawText
+	if r.Retry == nil {
+		r.Retry = c.retry
+	}
+	if r.OnAfterResponse == nil {
+		r.OnAfterResponse = c.onAfterResponse
+	}
+	if r.Auth == nil {
+		r.Auth = c.auth
+	}
+}
+
+// addHeaders adds the client's default headers not already set by the request.
+func (c *Client) addHeaders(headersMap http.Header) {
+	defaults := make(http.Header)
+	for _, header := range c.headers {
+		defaults.Add(header.name, header.value)
+	}
+	for name, values := range defaults {
+		if _, ok := headersMap[name]; !ok {
+			headersMap[name] = values
+		}
+	}
+}
+
+// transportFor returns the transport to use for r. Requests needing their own
+// TLS or proxy settings get a clone of the client's transport, cached by those
+// settings, so the shared transport is never modified. Only the maxTransports
+// most recently used clones are kept.
+func (c *Client) transportFor(r Request) (http.RoundTripper, error) {
+	transport := c.roundTripper()
+	if !r.Insecure && r.Proxy == "" {
+		return transport, nil
+	}
+
+	var proxyUrl *url.URL
+	proxyHeader := make(http.Header)
+	if r.Proxy != "" {
+		var err error
+		proxyUrl, err = url.Parse(r.Proxy)
+		if err != nil {
+			// proxy address is in a wrong format
+			return nil, err
+		}
+		for _, header := range r.proxyConnectHeaders {
+			proxyHeader.Add(header.name, header.value)
+		}
+	}
+
+	base, ok := transport.(*http.Transport)
+	if !ok && r.Proxy == "" {
+		// TLS settings can only be applied to an *http.Transport
+		return transport, nil
+	}
+
+	var headerKey strings.Builder
+	proxyHeader.Write(&headerKey)
+	key := transportKey{base: base, insecure: r.Insecure, proxy: r.Proxy, proxyHeader: headerKey.String()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[key]; ok {
+		c.touch(key)
+		return t, nil
+	}
+
+	var t *http.Transport
+	if base != nil {
+		t = base.Clone()
+	} else {
+		t = &http.Transport{DialContext: c.dialContext}
+	}
+	if r.Insecure {
+		if t.TLSClientConfig != nil {
+			t.TLSClientConfig.InsecureSkipVerify = true
+		} else {
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+	}
+	if proxyUrl != nil {
+		t.Proxy = http.ProxyURL(proxyUrl)
+		t.ProxyConnectHeader = proxyHeader
+	}
+
+	if c.transports == nil {
+		c.transports = make(map[transportKey]*http.Transport)
+	}
+	if len(c.lru) >= maxTransports {
+		oldest := c.lru[0]
+		c.lru = c.lru[1:]
+		// requests still using it finish, only its idle connections go away
+		c.transports[oldest].CloseIdleConnections()
+		delete(c.transports, oldest)
+	}
+	c.transports[key] = t
+	c.lru = append(c.lru, key)
+	return t, nil
+}
+
+// touch marks the transport of key as the most recently used.
+func (c *Client) touch(key transportKey) {
+	for i, k := range c.lru {
+		if k == key {
+			c.lru = append(append(c.lru[:i:i], c.lru[i+1:]...), key)
+			return
+		}
+	}
+}
+
+// maxResends is the number of times OnAfterResponse can resend a request.
+const maxResends = 3
+
+// Do sends the request using the client's transport and defaults. It is safe
+// to call Do concurrently with requests using different settings.
+//
+// The request's OnAfterResponse hook, if any, is called with the outcome and
+// may change the request and call Resend to send it again, in which case the
+// previous response is closed.
+func (c *Client) Do(r Request) (*Response, error) {
+	c.applyDefaults(&r)
+
+	for resends := 0; ; resends++ {
+		res, err := c.do(r)
+		if r.OnAfterResponse == nil {
+			return res, err
+		}
+
+		r.resend = false
+		r.OnAfterResponse(&r, res, err)
+		if !r.resend || resends >= maxResends || !r.replayable() {
+			return res, err
+		}
+
+		if res != nil {
+			if res.Body != nil {
+				res.Body.Close()
+			}
+			res.CancelRequest()
+		}
+	}
+}
+
+func (c *Client) do(r Request) (*Response, error) {
+	var resUri string
+	var redirectFailed bool
+
+	transport, err := c.transportFor(r)
+	if err != nil {
+		return nil, &Error{Err: err}
+	}
+
+	// every request gets its own client so per request settings never leak
+	// into the shared one.
+	client := *c.httpClient()
+	client.Transport = transport
+	if r.CookieJar != nil {
+		client.Jar = r.CookieJar
+	}
+
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+
+		if len(via) > r.MaxRedirects {
+			redirectFailed = true
+			return errMaxRedirects
+		}
+
+		resUri = req.URL.String()
+
+		//By default Golang will not redirect request headers
+		// https://code.google.com/p/go/issues/detail?id=4800&q=request%20header
+		if r.RedirectHeaders {
+			for key, val := range via[0].Header {
+				req.Header[key] = val
+			}
+		}
+		return nil
+	}
+
+	req, closeBody, err := r.newRequest(true)
+
+	if err != nil {
+		// we couldn't parse the URL.
+		return nil, &Error{Err: err}
+	}
+	c.addHeaders(req.Header)
+
+	if r.Auth != nil {
+		// authenticate last so credentials, such as signatures, see the
+		// request as changed by the other middleware.
+		auth := []Middleware{authenticate(r.Auth, req.URL.Host)}
+		client.Transport = chain(transport, c.middleware, r.Middleware, auth)
+	} else if len(c.middleware) > 0 || len(r.Middleware) > 0 {
+		client.Transport = chain(transport, c.middleware, r.Middleware)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	if closeBody != nil {
+		// net/http no longer closes a body which can be sent again, close it
+		// once the request is done
+		cancelContext := cancel
+		var once sync.Once
+		cancel = func() {
+			cancelContext()
+			once.Do(func() { closeBody.Close() })
+		}
+	}
+
+	if r.Timeout > 0 {
+		client.Timeout = r.Timeout
+	}
+
+	if r.ShowDebug {
+		dump, err := httputil.DumpRequest(req, true)
+		if err != nil {
+			log.Println(err)
+		}
+		log.Println(string(dump))
+	}
+
+	if r.OnBeforeRequest != nil {
+		r.OnBeforeRequest(&r, req)
+	}
+	var res *http.Response
+	if r.Retry != nil {
+		res, err = r.Retry.do(req, func(req *http.Request) (*http.Response, error) {
+			redirectFailed = false
+			resUri = ""
+			return client.Do(req)
+		})
+	} else {
+		res, err = client.Do(req)
+	}
+
+	if err != nil {
+		if !redirectFailed {
+			cancel()
+			return nil, newError(err)
+		}
+
+		//If redirect fails we still want to return response data
+		var response *Response
+		if res != nil {
+			response = &Response{res, resUri, &Body{reader: res.Body, contentType: res.Header.Get("Content-Type"), rawText: r.DisableCharsetDecoding, cancel: cancel}, req, cancel}
+		} else {
+			// there is no body to read, nothing needs the context anymore
+			cancel()
+			response = &Response{res, resUri, nil, req, cancel}
+		}
+
+		//If redirect fails and we haven't set a redirect count we shouldn't return an error
+		if r.MaxRedirects == 0 {
+			return response, r.checkStatus(response)
+		}
+
+		return response, newError(err)
+	}
+
+	response := &Response{res, resUri, &Body{reader: res.Body, contentType: res.Header.Get("Content-Type"), rawText: r.DisableCharsetDecoding, cancel: cancel}, req, cancel}
+	if reader := decompress(res.Body, res.Header.Get("Content-Encoding")); reader != nil {
+		// like net/http, describe the body as it is read rather than as sent
+		response.Body.compressedReader = reader
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	}
+
+	return response, r.checkStatus(response)
+}
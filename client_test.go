@@ -0,0 +1,293 @@
+package goreq
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestClient(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Client", func() {
+		var ts *httptest.Server
+		var requestHeaders http.Header
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					time.Sleep(200 * time.Millisecond)
+					w.WriteHeader(200)
+					return
+				}
+				requestHeaders = r.Header
+				if r.URL.Path == "/redirect" {
+					http.Redirect(w, r, "/", 301)
+					return
+				}
+				if r.URL.Path == "/setcookies" {
+					w.Header().Add("Set-Cookie", "foobar=42 ; Path=/")
+				}
+				w.WriteHeader(200)
+				fmt.Fprint(w, r.URL.Path)
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		g.It("Should own its transport and dialer", func() {
+			c := NewClient(WithConnectTimeout(100 * time.Millisecond))
+
+			Expect(c.transport).ShouldNot(BeIdenticalTo(DefaultTransport))
+			Expect(c.client).ShouldNot(BeIdenticalTo(DefaultClient))
+			Expect(c.dialer).ShouldNot(BeIdenticalTo(DefaultDialer))
+			Expect(c.dialer.Timeout).Should(Equal(100 * time.Millisecond))
+			Expect(DefaultDialer.Timeout).ShouldNot(Equal(100 * time.Millisecond))
+		})
+
+		g.It("Should use copies of the given dialer and transport", func() {
+			dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: time.Minute}
+			transport := &http.Transport{MaxIdleConns: 7}
+			c := NewClient(WithDialer(dialer), WithTransport(transport), WithConnectTimeout(time.Second))
+
+			Expect(c.dialer).ShouldNot(BeIdenticalTo(dialer))
+			Expect(c.dialer.KeepAlive).Should(Equal(time.Minute))
+			Expect(c.dialer.Timeout).Should(Equal(time.Second))
+			Expect(dialer.Timeout).Should(Equal(10 * time.Second))
+			Expect(c.transport).ShouldNot(BeIdenticalTo(transport))
+			Expect(c.transport.(*http.Transport).MaxIdleConns).Should(Equal(7))
+			Expect(c.transport.(*http.Transport).DialContext).ShouldNot(BeNil())
+			Expect(transport.DialContext).Should(BeNil())
+
+			res, err := c.Do(Request{Uri: ts.URL + "/foo"})
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("/foo"))
+		})
+
+		g.It("Should apply the connect timeout in any order", func() {
+			c := NewClient(WithConnectTimeout(time.Second), WithDialer(&net.Dialer{Timeout: 10 * time.Second}))
+
+			Expect(c.dialer.Timeout).Should(Equal(time.Second))
+		})
+
+		g.It("Should use the given TLS config", func() {
+			tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			}))
+			defer tlsServer.Close()
+
+			c := NewClient(WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+			res, err := c.Do(Request{Uri: tlsServer.URL})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+
+			_, err = NewClient().Do(Request{Uri: tlsServer.URL})
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		g.It("Should send default headers", func() {
+			c := NewClient(WithDefaultHeader("X-Default", "foo"), WithUserAgent("goreq-client"))
+
+			res, err := c.Do(Request{Uri: ts.URL})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(requestHeaders.Get("X-Default")).Should(Equal("foo"))
+			Expect(requestHeaders.Get("User-Agent")).Should(Equal("goreq-client"))
+		})
+
+		g.It("Should let the request override default headers", func() {
+			c := NewClient(WithDefaultHeader("X-Default", "foo"), WithUserAgent("goreq-client"))
+
+			res, err := c.Do(Request{Uri: ts.URL, UserAgent: "foobaragent"}.WithHeader("X-Default", "bar"))
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(requestHeaders["X-Default"]).Should(Equal([]string{"bar"}))
+			Expect(requestHeaders.Get("User-Agent")).Should(Equal("foobaragent"))
+		})
+
+		g.It("Should use the default timeout", func() {
+			c := NewClient(WithTimeout(100 * time.Millisecond))
+
+			res, err := c.Do(Request{Uri: ts.URL + "/slow"})
+			Expect(res).Should(BeNil())
+			Expect(err.(*Error).Timeout()).Should(BeTrue())
+
+			res, err = c.Do(Request{Uri: ts.URL + "/slow", Timeout: time.Second})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+		})
+
+		g.It("Should use the default cookie jar", func() {
+			uri, _ := url.Parse(ts.URL)
+			jar, _ := cookiejar.New(nil)
+			c := NewClient(WithCookieJar(jar))
+
+			_, err := c.Do(Request{Uri: ts.URL + "/setcookies"})
+			Expect(err).Should(BeNil())
+			Expect(jar.Cookies(uri)).Should(HaveLen(1))
+
+			_, err = c.Do(Request{Uri: ts.URL})
+			Expect(err).Should(BeNil())
+			Expect(requestHeaders.Get("Cookie")).Should(Equal("foobar=42"))
+		})
+
+		g.It("Should use the default redirect policy", func() {
+			c := NewClient(WithMaxRedirects(1), WithRedirectHeaders(true))
+
+			res, err := c.Do(Request{Uri: ts.URL + "/redirect"}.WithHeader("X-Custom", "foo"))
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(res.Uri).Should(Equal(ts.URL + "/"))
+			Expect(requestHeaders.Get("X-Custom")).Should(Equal("foo"))
+
+			res, err = NewClient().Do(Request{Uri: ts.URL + "/redirect"})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(301))
+		})
+	})
+
+	g.Describe("Concurrent requests", func() {
+		var ts, tlsServer, proxy *httptest.Server
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/redirect/") {
+					n := strings.TrimPrefix(r.URL.Path, "/redirect/")
+					if n != "0" {
+						http.Redirect(w, r, fmt.Sprintf("/redirect/%c", n[0]-1), 302)
+						return
+					}
+				}
+				fmt.Fprint(w, "direct")
+			}))
+			tlsServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "tls")
+			}))
+			proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "proxied")
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+			tlsServer.Close()
+			proxy.Close()
+		})
+
+		hammer := func(c *Client) []string {
+			var wg sync.WaitGroup
+			failures := make(chan string, 200)
+			for i := 0; i < 200; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var res *Response
+					var err error
+					var expected string
+					switch i % 5 {
+					case 0:
+						res, err = c.Do(Request{Uri: tlsServer.URL, Insecure: true})
+						expected = "tls"
+					case 1:
+						res, err = c.Do(Request{Uri: tlsServer.URL})
+						if err == nil {
+							failures <- fmt.Sprintf("%d: secure request to self signed server succeeded", i)
+						}
+						return
+					case 2:
+						res, err = c.Do(Request{Uri: ts.URL, Proxy: proxy.URL})
+						expected = "proxied"
+					case 3:
+						res, err = c.Do(Request{Uri: ts.URL + "/redirect/3", MaxRedirects: 3})
+						expected = "direct"
+					case 4:
+						res, err = c.Do(Request{Uri: ts.URL + "/redirect/3", MaxRedirects: 1})
+						if err == nil || res.StatusCode != 302 {
+							failures <- fmt.Sprintf("%d: MaxRedirects not honored", i)
+						}
+						if res != nil && res.Body != nil {
+							res.Body.Close()
+						}
+						return
+					}
+					if err != nil {
+						failures <- fmt.Sprintf("%d: %v", i, err)
+						return
+					}
+					str, _ := res.Body.ToString()
+					res.Body.Close()
+					if str != expected {
+						failures <- fmt.Sprintf("%d: expected %q, got %q", i, expected, str)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(failures)
+
+			var result []string
+			for f := range failures {
+				result = append(result, f)
+			}
+			return result
+		}
+
+		g.It("Should not leak settings between requests of the default client", func() {
+			Expect(hammer(defaultClient)).Should(BeEmpty())
+		})
+
+		g.It("Should not leak settings between requests of a client", func() {
+			Expect(hammer(NewClient())).Should(BeEmpty())
+		})
+
+		g.It("Should reuse transports for the same settings", func() {
+			c := NewClient()
+			t1, _ := c.transportFor(Request{Insecure: true})
+			t2, _ := c.transportFor(Request{Insecure: true})
+			t3, _ := c.transportFor(Request{Insecure: true, Proxy: proxy.URL})
+			t4, _ := c.transportFor(Request{})
+
+			Expect(t1).Should(BeIdenticalTo(t2))
+			Expect(t1).ShouldNot(BeIdenticalTo(t3))
+			Expect(t4).Should(BeIdenticalTo(c.transport))
+		})
+
+		g.It("Should only keep the most recently used transports", func() {
+			c := NewClient()
+			request := func(i int) Request {
+				return Request{Proxy: proxy.URL}.WithProxyConnectHeader("Proxy-Authorization", fmt.Sprint("Bearer ", i))
+			}
+			first, _ := c.transportFor(request(0))
+			second, _ := c.transportFor(request(1))
+			for i := 2; i < maxTransports; i++ {
+				c.transportFor(request(i))
+			}
+			// using the first one again makes the second the oldest
+			again, _ := c.transportFor(request(0))
+			Expect(again).Should(BeIdenticalTo(first))
+
+			c.transportFor(request(maxTransports))
+			Expect(c.transports).Should(HaveLen(maxTransports))
+			again, _ = c.transportFor(request(0))
+			Expect(again).Should(BeIdenticalTo(first))
+			again, _ = c.transportFor(request(1))
+			Expect(again).ShouldNot(BeIdenticalTo(second))
+			Expect(c.transports).Should(HaveLen(maxTransports))
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package goreq
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedContentType is returned when no codec is registered for the
+// content type of a body.
+var ErrUnsupportedContentType = errors.New("goreq: unsupported content type")
+
+// Codec encodes and decodes bodies of a given media type. Request bodies are
+// encoded with the codec of the request ContentType, and response bodies
+// decoded by Body.Decode with the codec of their Content-Type.
+type Codec struct {
+	// Encode returns the encoding of v.
+	Encode func(v interface{}) ([]byte, error)
+	// Decode reads a body from r into v.
+	Decode func(r io.Reader, v interface{}) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byType map[string]Codec
+}{byType: map[string]Codec{
+	"application/json":                  jsonCodec,
+	"application/xml":                   xmlCodec,
+	"text/xml":                          xmlCodec,
+	"application/x-www-form-urlencoded": formCodec,
+	"text/plain":                        textCodec,
+}}
+
+// RegisterCodec sets the codec used for bodies of mediaType, such as
+// "application/x-msgpack", replacing the codec registered for it, if any.
+// Either of its functions may be nil when only encoding or decoding is
+// needed.
+func RegisterCodec(mediaType string, codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byType[strings.ToLower(mediaType)] = codec
+}
+
+// codecFor returns the codec registered for contentType. Structured syntax
+// suffixes, as in application/problem+json, fall back to the codec of their
+// base type.
+func codecFor(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Codec{}, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+	if codec, ok := codecs.byType[mediaType]; ok {
+		return codec, nil
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		if codec, ok := codecs.byType["application/"+mediaType[i+1:]]; ok {
+			return codec, nil
+		}
+	}
+	return Codec{}, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+}
+
+var jsonCodec = Codec{
+	Encode: json.Marshal,
+	Decode: func(r io.Reader, v interface{}) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+}
+
+var xmlCodec = Codec{
+	Encode: xml.Marshal,
+	Decode: func(r io.Reader, v interface{}) error {
+		return xml.NewDecoder(r).Decode(v)
+	},
+}
+
+// formCodec encodes values like query strings and decodes into a
+// *url.Values, *map[string][]string or *map[string]string.
+var formCodec = Codec{
+	Encode: func(v interface{}) ([]byte, error) {
+		param, err := paramParse(v)
+		return []byte(param), err
+	},
+	Decode: func(r io.Reader, v interface{}) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case *url.Values:
+			*v = values
+		case *map[string][]string:
+			*v = values
+		case *map[string]string:
+			*v = make(map[string]string, len(values))
+			for name := range values {
+				(*v)[name] = values.Get(name)
+			}
+		default:
+			return fmt.Errorf("goreq: can't decode a form into %T", v)
+		}
+		return nil
+	},
+}
+
+// textCodec encodes values as fmt.Sprint does and decodes into a *string or
+// *[]byte.
+var textCodec = Codec{
+	Encode: func(v interface{}) ([]byte, error) {
+		return []byte(fmt.Sprint(v)), nil
+	},
+	Decode: func(r io.Reader, v interface{}) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case *string:
+			*v = string(b)
+		case *[]byte:
+			*v = b
+		default:
+			return fmt.Errorf("goreq: can't decode text into %T", v)
+		}
+		return nil
+	},
+}
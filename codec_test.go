@@ -0,0 +1,225 @@
+package goreq
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+type codecItem struct {
+	Name  string `json:"name" xml:"name"`
+	Count int    `json:"count" xml:"count"`
+}
+
+func TestCodec(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Body decoding", func() {
+		var ts *httptest.Server
+
+		g.Before(func() {
+			bodies := map[string]string{
+				"application/json; charset=utf-8":   `{"name":"foo","count":2}`,
+				"application/problem+json":          `{"name":"problem","count":1}`,
+				"application/xml":                   `<item><name>foo</name><count>2</count></item>`,
+				"application/atom+xml":              `<item><name>atom</name><count>3</count></item>`,
+				"application/x-www-form-urlencoded": "name=foo&count=2&count=3",
+				"text/plain":                        "plain text",
+				"application/x-upper":               "upper",
+				"application/octet-stream":          "bytes",
+			}
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType := r.URL.Query().Get("type")
+				w.Header().Set("Content-Type", contentType)
+				fmt.Fprint(w, bodies[contentType])
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		get := func(contentType string) *Body {
+			res, err := Request{Uri: ts.URL, QueryString: url.Values{"type": {contentType}}}.Do()
+			Expect(err).Should(BeNil())
+			return res.Body
+		}
+
+		g.It("Should decode XML with FromXmlTo", func() {
+			var item codecItem
+			Expect(get("application/xml").FromXmlTo(&item)).Should(BeNil())
+			Expect(item).Should(Equal(codecItem{Name: "foo", Count: 2}))
+		})
+
+		g.It("Should decode JSON", func() {
+			var item codecItem
+			Expect(get("application/json; charset=utf-8").Decode(&item)).Should(BeNil())
+			Expect(item).Should(Equal(codecItem{Name: "foo", Count: 2}))
+		})
+
+		g.It("Should decode XML", func() {
+			var item codecItem
+			Expect(get("application/xml").Decode(&item)).Should(BeNil())
+			Expect(item).Should(Equal(codecItem{Name: "foo", Count: 2}))
+		})
+
+		g.It("Should decode structured syntax suffixes", func() {
+			var item codecItem
+			Expect(get("application/problem+json").Decode(&item)).Should(BeNil())
+			Expect(item.Name).Should(Equal("problem"))
+			Expect(get("application/atom+xml").Decode(&item)).Should(BeNil())
+			Expect(item.Name).Should(Equal("atom"))
+		})
+
+		g.It("Should decode forms", func() {
+			var values url.Values
+			Expect(get("application/x-www-form-urlencoded").Decode(&values)).Should(BeNil())
+			Expect(values).Should(Equal(url.Values{"name": {"foo"}, "count": {"2", "3"}}))
+
+			var m map[string]string
+			Expect(get("application/x-www-form-urlencoded").Decode(&m)).Should(BeNil())
+			Expect(m).Should(Equal(map[string]string{"name": "foo", "count": "2"}))
+
+			var item codecItem
+			Expect(get("application/x-www-form-urlencoded").Decode(&item)).ShouldNot(BeNil())
+		})
+
+		g.It("Should decode plain text", func() {
+			var str string
+			Expect(get("text/plain").Decode(&str)).Should(BeNil())
+			Expect(str).Should(Equal("plain text"))
+
+			var b []byte
+			Expect(get("text/plain").Decode(&b)).Should(BeNil())
+			Expect(b).Should(Equal([]byte("plain text")))
+		})
+
+		g.It("Should use registered codecs", func() {
+			RegisterCodec("application/x-upper", Codec{
+				Decode: func(r io.Reader, v interface{}) error {
+					b, err := ioutil.ReadAll(r)
+					*v.(*string) = strings.ToUpper(string(b))
+					return err
+				},
+			})
+
+			var str string
+			Expect(get("application/x-upper").Decode(&str)).Should(BeNil())
+			Expect(str).Should(Equal("UPPER"))
+		})
+
+		g.It("Should fail for content types without codec", func() {
+			var str string
+			err := get("application/octet-stream").Decode(&str)
+			Expect(errors.Is(err, ErrUnsupportedContentType)).Should(BeTrue())
+			Expect(err.Error()).Should(Equal(`goreq: unsupported content type "application/octet-stream"`))
+
+			err = get("").Decode(&str)
+			Expect(errors.Is(err, ErrUnsupportedContentType)).Should(BeTrue())
+		})
+	})
+
+	g.Describe("Body encoding", func() {
+		var ts *httptest.Server
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+				io.Copy(w, r.Body)
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		post := func(body interface{}, contentType string) (string, string) {
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: body, ContentType: contentType}.Do()
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			return str, res.Header.Get("Content-Type")
+		}
+
+		item := codecItem{Name: "foo", Count: 2}
+
+		g.It("Should encode JSON without content type", func() {
+			str, contentType := post(item, "")
+			Expect(str).Should(Equal(`{"name":"foo","count":2}`))
+			Expect(contentType).Should(Equal(""))
+		})
+
+		g.It("Should encode XML", func() {
+			str, contentType := post(item, "application/xml; charset=utf-8")
+			Expect(str).Should(Equal(`<codecItem><name>foo</name><count>2</count></codecItem>`))
+			Expect(contentType).Should(Equal("application/xml; charset=utf-8"))
+
+			str, _ = post(item, "application/soap+xml")
+			Expect(str).Should(Equal(`<codecItem><name>foo</name><count>2</count></codecItem>`))
+		})
+
+		g.It("Should encode forms", func() {
+			str, _ := post(item, "application/x-www-form-urlencoded")
+			Expect(str).Should(Equal("count=2&name=foo"))
+		})
+
+		g.It("Should encode text", func() {
+			str, _ := post(42, "text/plain")
+			Expect(str).Should(Equal("42"))
+		})
+
+		g.It("Should fall back to JSON for content types without encoder", func() {
+			str, contentType := post(item, "application/vnd.foo")
+			Expect(str).Should(Equal(`{"name":"foo","count":2}`))
+			Expect(contentType).Should(Equal("application/vnd.foo"))
+		})
+
+		g.It("Should use registered codecs both ways", func() {
+			RegisterCodec("application/x-pipe", Codec{
+				Encode: func(v interface{}) ([]byte, error) {
+					item := v.(codecItem)
+					return []byte(fmt.Sprintf("%s|%d", item.Name, item.Count)), nil
+				},
+				Decode: func(r io.Reader, v interface{}) error {
+					b, err := ioutil.ReadAll(r)
+					if err != nil {
+						return err
+					}
+					item := v.(*codecItem)
+					_, err = fmt.Sscanf(strings.Replace(string(b), "|", " ", 1), "%s %d", &item.Name, &item.Count)
+					return err
+				},
+			})
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: item, ContentType: "application/x-pipe"}.Do()
+			Expect(err).Should(BeNil())
+			var decoded codecItem
+			Expect(res.Body.Decode(&decoded)).Should(BeNil())
+			Expect(decoded).Should(Equal(item))
+		})
+
+		g.It("Should return encoding errors", func() {
+			_, err := Request{Method: "POST", Uri: ts.URL, Body: make(chan int), ContentType: "application/xml"}.Do()
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		g.It("Should fail to decode with encode only codecs", func() {
+			RegisterCodec("application/x-encode-only", Codec{Encode: jsonCodec.Encode})
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: item, ContentType: "application/x-encode-only"}.Do()
+			Expect(err).Should(BeNil())
+			var decoded codecItem
+			Expect(errors.Is(res.Body.Decode(&decoded), ErrUnsupportedContentType)).Should(BeTrue())
+		})
+	})
+}
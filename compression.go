@@ -0,0 +1,242 @@
+package goreq
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompression asks a compression for its own default level.
+const DefaultCompression = -1
+
+// WithLevel returns a copy of the compression writing request bodies at the
+// given level. Levels are specific to each encoding: 1 to 9 for gzip and
+// deflate, 0 to 11 for brotli and 1 to 22 for zstd.
+func (c *compression) WithLevel(level int) *compression {
+	clone := *c
+	clone.level = level
+	return &clone
+}
+
+// WithMinSize returns a copy of the compression sending bodies smaller than
+// size bytes uncompressed, as compressing them isn't worth it.
+func (c *compression) WithMinSize(size int64) *compression {
+	clone := *c
+	clone.minSize = size
+	return &clone
+}
+
+// body returns the reader sending b compressed, and compressed is false when b
+// is smaller than the minimum size and sent as is. size is the length of b, or
+// -1 when unknown.
+//
+// Bodies already held in memory are compressed at once, and net/http replays
+// them. Other bodies are compressed while they are sent, and replay, when not
+// nil, opens them again for GetBody.
+func (c *compression) body(b io.Reader, size int64, replay func() (io.ReadCloser, error)) (body io.Reader, getBody func() (io.ReadCloser, error), compressed bool, err error) {
+	if size >= 0 && size < c.minSize {
+		return b, nil, false, nil
+	}
+
+	switch b.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
+		var buffer bytes.Buffer
+		if err := c.compress(&buffer, b); err != nil {
+			return nil, nil, false, err
+		}
+		return &buffer, nil, true, nil
+	}
+
+	var closer, _ = b.(io.Closer)
+	if size < 0 && c.minSize > 0 {
+		// only buffer what's needed to tell whether the body is small enough
+		peeked := make([]byte, c.minSize)
+		n, err := io.ReadFull(b, peeked)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if closer != nil {
+				closer.Close()
+			}
+			return bytes.NewReader(peeked[:n]), nil, false, nil
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+		b = io.MultiReader(bytes.NewReader(peeked), b)
+	}
+
+	body = c.compressing(b, closer)
+	if replay != nil {
+		getBody = func() (io.ReadCloser, error) {
+			source, err := replay()
+			if err != nil {
+				return nil, err
+			}
+			return c.compressing(source, source), nil
+		}
+	}
+	return body, getBody, true, nil
+}
+
+// compressing returns a reader compressing src as it is read, so that only a
+// small part of the body is held in memory at once. closer, when not nil, is
+// closed along with it.
+func (c *compression) compressing(src io.Reader, closer io.Closer) *pipeReader {
+	return &pipeReader{closer: closer, write: func(w io.Writer) error {
+		return c.compress(w, src)
+	}}
+}
+
+// compress writes src compressed to w.
+func (c *compression) compress(w io.Writer, src io.Reader) error {
+	writer, err := c.writer(w, c.level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, src); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// Brotli compresses request bodies with brotli, sent as
+// "Content-Encoding: br".
+func Brotli() *compression {
+	reader := func(buffer io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(brotli.NewReader(buffer)), nil
+	}
+	writer := func(buffer io.Writer, level int) (io.WriteCloser, error) {
+		if level == DefaultCompression {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(buffer, level), nil
+	}
+	return &compression{writer: writer, reader: reader, level: DefaultCompression, ContentEncoding: "br"}
+}
+
+// Zstd compresses request bodies with Zstandard, sent as
+// "Content-Encoding: zstd".
+func Zstd() *compression {
+	reader := func(buffer io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(buffer, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	writer := func(buffer io.Writer, level int) (io.WriteCloser, error) {
+		encoderLevel := zstd.SpeedDefault
+		if level != DefaultCompression {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(buffer, zstd.WithEncoderLevel(encoderLevel))
+	}
+	return &compression{writer: writer, reader: reader, level: DefaultCompression, ContentEncoding: "zstd"}
+}
+
+// RegisterCompression makes a content encoding known to goreq: responses using
+// it are decompressed with reader and it is listed in Accept-Encoding. The
+// returned compression can be used as a Request's Compression, writing bodies
+// with writer. Registering an encoding again replaces it.
+func RegisterCompression(contentEncoding string, reader func(io.Reader) (io.ReadCloser, error), writer func(w io.Writer, level int) (io.WriteCloser, error)) *compression {
+	c := &compression{writer: writer, reader: reader, level: DefaultCompression, ContentEncoding: contentEncoding}
+	name := strings.ToLower(contentEncoding)
+
+	compressions.Lock()
+	defer compressions.Unlock()
+	if _, ok := compressions.byEncoding[name]; !ok {
+		compressions.accept = append(compressions.accept, name)
+	}
+	compressions.byEncoding[name] = c
+	return c
+}
+
+var compressions = struct {
+	sync.RWMutex
+	byEncoding map[string]*compression
+	// accept is the Accept-Encoding header listing them, most preferred
+	// first.
+	accept []string
+}{
+	byEncoding: map[string]*compression{
+		"gzip":    Gzip(),
+		"deflate": Deflate(),
+		"br":      Brotli(),
+		"zstd":    Zstd(),
+	},
+	accept: []string{"gzip", "deflate", "br", "zstd"},
+}
+
+// acceptEncoding returns the Accept-Encoding header listing every supported
+// content encoding.
+func acceptEncoding() string {
+	compressions.RLock()
+	defer compressions.RUnlock()
+	return strings.Join(compressions.accept, ", ")
+}
+
+// decompress returns a reader decoding body according to contentEncoding,
+// which may list several encodings in the order they were applied. It returns
+// nil when the body isn't encoded or uses an unknown encoding.
+func decompress(body io.ReadCloser, contentEncoding string) io.ReadCloser {
+	var encodings []*compression
+	compressions.RLock()
+	for _, name := range strings.Split(contentEncoding, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "identity" {
+			continue
+		}
+		c, ok := compressions.byEncoding[name]
+		if !ok {
+			compressions.RUnlock()
+			return nil
+		}
+		encodings = append(encodings, c)
+	}
+	compressions.RUnlock()
+	if len(encodings) == 0 {
+		return nil
+	}
+
+	var reader io.ReadCloser = body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		reader = &lazyReader{source: reader, open: encodings[i].reader}
+	}
+	return reader
+}
+
+// lazyReader creates its reader when first read, so that empty bodies, such as
+// the ones of HEAD requests, don't fail when the response is received.
+type lazyReader struct {
+	source io.ReadCloser
+	open   func(io.Reader) (io.ReadCloser, error)
+	reader io.ReadCloser
+	err    error
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.reader == nil && r.err == nil {
+		r.reader, r.err = r.open(r.source)
+	}
+	if r.err != nil {
+		r.reader = nil
+		return 0, r.err
+	}
+	return r.reader.Read(p)
+}
+
+func (r *lazyReader) Close() error {
+	var err error
+	if r.reader != nil {
+		err = r.reader.Close()
+	}
+	if sourceErr := r.source.Close(); err == nil {
+		err = sourceErr
+	}
+	return err
+}
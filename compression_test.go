@@ -0,0 +1,329 @@
+package goreq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	. "github.com/franela/goblin"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/gomega"
+)
+
+// encode applies the given content encodings to body, in order.
+func encode(body []byte, encodings ...string) []byte {
+	for _, encoding := range encodings {
+		var buffer bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buffer)
+		case "deflate":
+			w = zlib.NewWriter(&buffer)
+		case "br":
+			w = brotli.NewWriter(&buffer)
+		case "zstd":
+			w, _ = zstd.NewWriter(&buffer)
+		}
+		w.Write(body)
+		w.Close()
+		body = buffer.Bytes()
+	}
+	return body
+}
+
+func TestCompression(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Response decompression", func() {
+		var ts *httptest.Server
+		var lastReq *http.Request
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lastReq = r
+				body := []byte("foo")
+				switch r.URL.Path {
+				case "/gzip":
+					w.Header().Set("Content-Encoding", "gzip")
+					body = encode(body, "gzip")
+				case "/br":
+					w.Header().Set("Content-Encoding", "br")
+					body = encode(body, "br")
+				case "/zstd":
+					w.Header().Set("Content-Encoding", "zstd")
+					body = encode(body, "zstd")
+				case "/stacked":
+					w.Header().Set("Content-Encoding", "deflate, gzip")
+					body = encode(body, "deflate", "gzip")
+				case "/unknown":
+					w.Header().Set("Content-Encoding", "gzip, unknown")
+					body = []byte("unknown")
+				case "/empty":
+					w.Header().Set("Content-Encoding", "gzip")
+					w.WriteHeader(204)
+					return
+				}
+				w.Write(body)
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		get := func(r Request) string {
+			res, err := r.Do()
+			Expect(err).Should(BeNil())
+			str, err := res.Body.ToString()
+			Expect(err).Should(BeNil())
+			return str
+		}
+
+		g.It("Should accept every supported encoding", func() {
+			get(Request{Uri: ts.URL})
+			Expect(lastReq.Header.Get("Accept-Encoding")).Should(Equal("gzip, deflate, br, zstd"))
+		})
+
+		g.It("Should keep the Accept-Encoding set by the request", func() {
+			get(Request{Uri: ts.URL}.WithHeader("Accept-Encoding", "identity"))
+			Expect(lastReq.Header.Get("Accept-Encoding")).Should(Equal("identity"))
+		})
+
+		g.It("Should decompress responses without request Compression", func() {
+			Expect(get(Request{Uri: ts.URL + "/gzip"})).Should(Equal("foo"))
+		})
+
+		g.It("Should drop the headers of the compressed body", func() {
+			res, err := Request{Uri: ts.URL + "/gzip"}.Do()
+			Expect(err).Should(BeNil())
+			defer res.Body.Close()
+
+			Expect(res.Header.Get("Content-Encoding")).Should(Equal(""))
+			Expect(res.Header.Get("Content-Length")).Should(Equal(""))
+			Expect(res.ContentLength).Should(Equal(int64(-1)))
+			Expect(res.Uncompressed).Should(BeTrue())
+
+			res, err = Request{Uri: ts.URL + "/unknown"}.Do()
+			Expect(err).Should(BeNil())
+			defer res.Body.Close()
+
+			Expect(res.Header.Get("Content-Encoding")).Should(Equal("gzip, unknown"))
+			Expect(res.ContentLength).Should(Equal(int64(len("unknown"))))
+			Expect(res.Uncompressed).Should(BeFalse())
+		})
+
+		g.It("Should decompress brotli and zstd responses", func() {
+			Expect(get(Request{Uri: ts.URL + "/br"})).Should(Equal("foo"))
+			Expect(get(Request{Uri: ts.URL + "/zstd"})).Should(Equal("foo"))
+		})
+
+		g.It("Should decompress stacked encodings", func() {
+			Expect(get(Request{Uri: ts.URL + "/stacked"})).Should(Equal("foo"))
+		})
+
+		g.It("Should leave unknown encodings as is", func() {
+			Expect(get(Request{Uri: ts.URL + "/unknown"})).Should(Equal("unknown"))
+		})
+
+		g.It("Should not fail on empty bodies", func() {
+			Expect(get(Request{Uri: ts.URL + "/empty"})).Should(Equal(""))
+			Expect(get(Request{Method: "HEAD", Uri: ts.URL + "/gzip"})).Should(Equal(""))
+		})
+
+		g.It("Should only send Content-Encoding with a compressed body", func() {
+			get(Request{Uri: ts.URL, Compression: Gzip()})
+			Expect(lastReq.Header.Get("Content-Encoding")).Should(Equal(""))
+
+			get(Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: Gzip()})
+			Expect(lastReq.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+		})
+	})
+
+	g.Describe("Request compression", func() {
+		var ts *httptest.Server
+		var mu sync.Mutex
+		var attempts int
+
+		g.Before(func() {
+			// The server echoes the body as received, leaving the client to
+			// decompress it according to the request's Content-Encoding.
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				n := attempts
+				mu.Unlock()
+				if r.URL.Path == "/flaky" && n < 2 {
+					w.WriteHeader(503)
+					return
+				}
+				w.Header().Set("Content-Encoding", r.Header.Get("Content-Encoding"))
+				io.Copy(w, r.Body)
+			}))
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			attempts = 0
+			mu.Unlock()
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		post := func(c *compression) string {
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: c}.Do()
+			Expect(err).Should(BeNil())
+			str, err := res.Body.ToString()
+			Expect(err).Should(BeNil())
+			return str
+		}
+
+		g.It("Should compress with brotli and zstd", func() {
+			Expect(post(Brotli())).Should(Equal("foo"))
+			Expect(post(Zstd())).Should(Equal("foo"))
+		})
+
+		g.It("Should compress at the given level", func() {
+			Expect(post(Gzip().WithLevel(gzip.BestSpeed))).Should(Equal("foo"))
+			Expect(post(Deflate().WithLevel(zlib.BestCompression))).Should(Equal("foo"))
+			Expect(post(Brotli().WithLevel(11))).Should(Equal("foo"))
+			Expect(post(Zstd().WithLevel(19))).Should(Equal("foo"))
+		})
+
+		g.It("Should not change the compression WithLevel is called on", func() {
+			c := Gzip()
+			c.WithLevel(gzip.BestSpeed)
+			Expect(c.level).Should(Equal(DefaultCompression))
+		})
+
+		g.It("Should fail on invalid levels", func() {
+			_, err := Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: Gzip().WithLevel(42)}.Do()
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		g.It("Should use registered compressions", func() {
+			c := RegisterCompression("x-base64", func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+			}, func(w io.Writer, level int) (io.WriteCloser, error) {
+				return base64.NewEncoder(base64.StdEncoding, w), nil
+			})
+			defer func() {
+				compressions.Lock()
+				delete(compressions.byEncoding, "x-base64")
+				compressions.accept = compressions.accept[:len(compressions.accept)-1]
+				compressions.Unlock()
+			}()
+
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: c}.NewRequest()
+			Expect(err).Should(BeNil())
+			Expect(req.Header.Get("Accept-Encoding")).Should(Equal("gzip, deflate, br, zstd, x-base64"))
+			b, _ := ioutil.ReadAll(req.Body)
+			Expect(string(b)).Should(Equal("Zm9v"))
+
+			Expect(post(c)).Should(Equal("foo"))
+		})
+
+		g.It("Should compress in-memory bodies at once", func() {
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: Gzip()}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			Expect(req.GetBody).ShouldNot(BeNil())
+			Expect(req.ContentLength).Should(BeNumerically(">", 0))
+		})
+
+		g.It("Should stream other bodies", func() {
+			body := ioutil.NopCloser(strings.NewReader("foo"))
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: body, Compression: Gzip()}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			_, ok := req.Body.(*pipeReader)
+			Expect(ok).Should(BeTrue())
+			Expect(req.GetBody).Should(BeNil())
+			Expect(req.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+		})
+
+		g.It("Should send streamed bodies", func() {
+			data := strings.Repeat("foo", 100000)
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: io.MultiReader(strings.NewReader(data)), Compression: Zstd()}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal(data))
+		})
+
+		g.It("Should close streamed bodies never read", func() {
+			source := &closeRecorder{Reader: strings.NewReader("foo")}
+			req, _ := Request{Method: "POST", Uri: ts.URL, Body: source, Compression: Gzip()}.NewRequest()
+
+			Expect(req.Body.Close()).Should(BeNil())
+			Expect(source.closed).Should(BeTrue())
+			_, err := req.Body.Read(make([]byte, 1))
+			Expect(err).Should(Equal(io.ErrClosedPipe))
+		})
+
+		g.It("Should send bodies under the minimum size uncompressed", func() {
+			c := Gzip().WithMinSize(4)
+
+			req, _ := Request{Method: "POST", Uri: ts.URL, Body: "foo", Compression: c}.NewRequest()
+			Expect(req.Header.Get("Content-Encoding")).Should(Equal(""))
+
+			req, _ = Request{Method: "POST", Uri: ts.URL, Body: "fooo", Compression: c}.NewRequest()
+			Expect(req.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+
+			source := &closeRecorder{Reader: strings.NewReader("foo")}
+			req, _ = Request{Method: "POST", Uri: ts.URL, Body: source, Compression: c}.NewRequest()
+			Expect(req.Header.Get("Content-Encoding")).Should(Equal(""))
+			Expect(req.GetBody).ShouldNot(BeNil())
+			Expect(source.closed).Should(BeTrue())
+			b, _ := ioutil.ReadAll(req.Body)
+			Expect(string(b)).Should(Equal("foo"))
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: io.MultiReader(strings.NewReader("foobar")), Compression: c}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.Request.Header.Get("Content-Encoding")).Should(Equal("gzip"))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("foobar"))
+		})
+
+		g.It("Should replay compressed bodies", func() {
+			policy := DefaultRetryPolicy()
+			policy.RetryNonIdempotent = true
+
+			res, err := Request{Method: "POST", Uri: ts.URL + "/flaky", Body: "foo", Compression: Gzip(), Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("foo"))
+
+			body := NewMultipart().AddField("foo", "bar")
+			res, err = Request{Method: "POST", Uri: ts.URL + "/flaky", Body: body, Compression: Gzip(), Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			str, _ = res.Body.ToString()
+			Expect(str).Should(ContainSubstring("bar"))
+		})
+	})
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
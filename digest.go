@@ -0,0 +1,288 @@
+package goreq
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestAuth implements the HTTP Digest authentication scheme of RFC 7616.
+type digestAuth struct {
+	username string
+	password string
+	cnonce   func() string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        int
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       []string
+	hash      func() hash.Hash
+	sess      bool
+}
+
+// Digest authenticates requests with the Digest scheme, supporting the MD5
+// and SHA-256 algorithms, their -sess variants and the auth and auth-int
+// qualities of protection.
+//
+// The first request is sent without credentials and answered with the
+// server's challenge, after which it is sent again. Later requests using the
+// same authenticator, for instance through a Client, reuse the challenge and
+// keep counting its nonce uses until the server sends a new one.
+func Digest(username string, password string) *digestAuth {
+	return &digestAuth{username: username, password: password, cnonce: randomNonce}
+}
+
+func randomNonce() string {
+	b := make([]byte, 16)
+	io.ReadFull(rand.Reader, b)
+	return hex.EncodeToString(b)
+}
+
+func (a *digestAuth) Apply(req *http.Request) error {
+	a.mu.Lock()
+	c := a.challenge
+	if c == nil {
+		// wait for the server's challenge
+		a.mu.Unlock()
+		return nil
+	}
+	a.nc++
+	nc := a.nc
+	a.mu.Unlock()
+
+	qop, err := c.chooseQop(req)
+	if err != nil {
+		return err
+	}
+
+	h := func(s string) string {
+		digest := c.hash()
+		io.WriteString(digest, s)
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	uri := req.URL.RequestURI()
+	cnonce := a.cnonce()
+	ha1 := h(a.username + ":" + c.realm + ":" + a.password)
+	if c.sess {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		body, err := hashBody(req, c.hash)
+		if err != nil {
+			return err
+		}
+		ha2 = h(req.Method + ":" + uri + ":" + body)
+	}
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = h(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, c.nonce, nc, cnonce, qop, ha2))
+	}
+
+	params := []string{
+		fmt.Sprintf(`username="%s"`, quoteEscaper.Replace(a.username)),
+		fmt.Sprintf(`realm="%s"`, quoteEscaper.Replace(c.realm)),
+		fmt.Sprintf(`nonce="%s"`, quoteEscaper.Replace(c.nonce)),
+		fmt.Sprintf(`uri="%s"`, quoteEscaper.Replace(uri)),
+		fmt.Sprintf(`algorithm=%s`, c.algorithm),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if c.opaque != "" {
+		params = append(params, fmt.Sprintf(`opaque="%s"`, quoteEscaper.Replace(c.opaque)))
+	}
+	if qop != "" {
+		params = append(params, "qop="+qop, fmt.Sprintf("nc=%08x", nc))
+	}
+	if qop != "" || c.sess {
+		params = append(params, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	req.Header.Set("Authorization", "Digest "+strings.Join(params, ", "))
+	return nil
+}
+
+// Refresh takes the Digest challenge of a 401 response. The request is only
+// sent again when the challenge is new or the previous nonce is stale, so
+// wrong credentials aren't tried twice.
+func (a *digestAuth) Refresh(res *http.Response) (bool, error) {
+	var challenge *digestChallenge
+	stale := false
+	for _, c := range parseChallenges(res.Header[http.CanonicalHeaderKey("WWW-Authenticate")]) {
+		if !strings.EqualFold(c.scheme, "Digest") {
+			continue
+		}
+		dc := newDigestChallenge(c.params)
+		// prefer SHA-256 when the server offers several algorithms
+		if dc != nil && (challenge == nil || strings.HasPrefix(dc.algorithm, "SHA-256")) {
+			challenge = dc
+			stale = strings.EqualFold(c.params["stale"], "true")
+		}
+	}
+	if challenge == nil {
+		return false, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.challenge != nil && a.challenge.nonce == challenge.nonce && !stale {
+		return false, nil
+	}
+	a.challenge = challenge
+	a.nc = 0
+	return true, nil
+}
+
+func newDigestChallenge(params map[string]string) *digestChallenge {
+	c := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+	}
+	if c.algorithm == "" {
+		c.algorithm = "MD5"
+	}
+	algorithm := strings.ToUpper(c.algorithm)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		c.sess = true
+		algorithm = strings.TrimSuffix(algorithm, "-SESS")
+	}
+	switch algorithm {
+	case "MD5":
+		c.hash = md5.New
+	case "SHA-256":
+		c.hash = sha256.New
+	default:
+		return nil
+	}
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if qop = strings.TrimSpace(qop); qop != "" {
+			c.qop = append(c.qop, qop)
+		}
+	}
+	return c
+}
+
+// chooseQop picks auth over auth-int, which needs to hash the body. It is
+// empty for servers following RFC 2069.
+func (c *digestChallenge) chooseQop(req *http.Request) (string, error) {
+	if len(c.qop) == 0 {
+		return "", nil
+	}
+	authInt := false
+	for _, qop := range c.qop {
+		switch qop {
+		case "auth":
+			return qop, nil
+		case "auth-int":
+			authInt = true
+		}
+	}
+	if !authInt {
+		return "", fmt.Errorf("unsupported digest qop %q", strings.Join(c.qop, ","))
+	}
+	if !rewindable(req) {
+		return "", errors.New("digest qop auth-int needs a body that can be read again")
+	}
+	return "auth-int", nil
+}
+
+func hashBody(req *http.Request, newHash func() hash.Hash) (string, error) {
+	digest := newHash()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(digest, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenges parses the challenges of WWW-Authenticate headers, several
+// of which may be given in a single header.
+func parseChallenges(headers []string) []challenge {
+	var challenges []challenge
+	for _, header := range headers {
+		s := header
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			i := strings.IndexAny(s, " \t,=")
+			if i == 0 {
+				// stray '=', skip it
+				s = s[1:]
+				continue
+			}
+			if i < 0 {
+				i = len(s)
+			}
+			token := s[:i]
+			s = strings.TrimLeft(s[i:], " \t")
+			if !strings.HasPrefix(s, "=") || len(challenges) == 0 {
+				challenges = append(challenges, challenge{scheme: token, params: make(map[string]string)})
+				continue
+			}
+
+			var value string
+			value, s = parseParamValue(strings.TrimLeft(s[1:], " \t"))
+			challenges[len(challenges)-1].params[strings.ToLower(token)] = value
+		}
+	}
+	return challenges
+}
+
+// parseParamValue returns the token or quoted string at the start of s and
+// what follows it.
+func parseParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexAny(s, " \t,")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return value.String(), ""
+}
@@ -0,0 +1,256 @@
+package goreq
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+// digestServer checks Digest credentials the way RFC 7616 servers do.
+type digestServer struct {
+	mu        sync.Mutex
+	algorithm string
+	qop       string
+	nonce     int
+	uses      int
+	maxUses   int
+	lastNc    int64
+	attempts  int
+}
+
+func (s *digestServer) challenge(w http.ResponseWriter, stale bool) {
+	header := fmt.Sprintf(`Digest realm="goreq", nonce="nonce-%d", opaque="opaque", algorithm=%s`, s.nonce, s.algorithm)
+	if s.qop != "" {
+		header += fmt.Sprintf(`, qop="%s"`, s.qop)
+	}
+	if stale {
+		header += ", stale=true"
+	}
+	w.Header().Add("WWW-Authenticate", `Basic realm="goreq"`)
+	w.Header().Add("WWW-Authenticate", header)
+	w.WriteHeader(401)
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+
+	body, _ := ioutil.ReadAll(r.Body)
+	challenges := parseChallenges(r.Header["Authorization"])
+	if len(challenges) != 1 || challenges[0].scheme != "Digest" {
+		s.challenge(w, false)
+		return
+	}
+	params := challenges[0].params
+
+	var newHash func() hash.Hash = md5.New
+	if strings.HasPrefix(s.algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(v string) string {
+		digest := newHash()
+		io.WriteString(digest, v)
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+	if params["nonce"] != nonce {
+		s.challenge(w, true)
+		return
+	}
+	nc, _ := strconv.ParseInt(params["nc"], 16, 64)
+	if s.qop != "" && nc <= s.lastNc {
+		// replayed nonce count
+		s.challenge(w, false)
+		return
+	}
+
+	ha1 := h("user:goreq:pass")
+	if strings.HasSuffix(s.algorithm, "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + params["cnonce"])
+	}
+	ha2 := h(r.Method + ":" + params["uri"])
+	if params["qop"] == "auth-int" {
+		ha2 = h(r.Method + ":" + params["uri"] + ":" + h(string(body)))
+	}
+	expected := h(ha1 + ":" + nonce + ":" + ha2)
+	if s.qop != "" {
+		expected = h(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+	}
+	if params["response"] != expected || params["uri"] != r.URL.RequestURI() || params["opaque"] != "opaque" {
+		s.challenge(w, false)
+		return
+	}
+
+	s.lastNc = nc
+	s.uses++
+	if s.maxUses > 0 && s.uses >= s.maxUses {
+		s.nonce++
+		s.uses = 0
+		s.lastNc = 0
+	}
+	fmt.Fprintf(w, "%s %s %s", params["qop"], params["nc"], body)
+}
+
+func TestDigest(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Digest challenges", func() {
+		g.It("Should parse several challenges in a header", func() {
+			challenges := parseChallenges([]string{
+				`Basic realm="foo", Digest realm="a \"quoted\", realm", qop="auth,auth-int", algorithm=SHA-256`,
+				`Bearer`,
+			})
+
+			Expect(challenges).Should(HaveLen(3))
+			Expect(challenges[0].scheme).Should(Equal("Basic"))
+			Expect(challenges[0].params).Should(Equal(map[string]string{"realm": "foo"}))
+			Expect(challenges[1].scheme).Should(Equal("Digest"))
+			Expect(challenges[1].params).Should(Equal(map[string]string{
+				"realm":     `a "quoted", realm`,
+				"qop":       "auth,auth-int",
+				"algorithm": "SHA-256",
+			}))
+			Expect(challenges[2].scheme).Should(Equal("Bearer"))
+		})
+
+		g.It("Should not choke on malformed headers", func() {
+			challenges := parseChallenges([]string{`=foo, Digest realm="unterminated`})
+
+			Expect(challenges).Should(HaveLen(2))
+			Expect(challenges[1].params["realm"]).Should(Equal("unterminated"))
+		})
+	})
+
+	g.Describe("Digest", func() {
+		rfcResponse := func(algorithm string) string {
+			auth := Digest("Mufasa", "Circle of Life")
+			auth.cnonce = func() string { return "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ" }
+			res := &http.Response{Header: http.Header{"Www-Authenticate": []string{
+				`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=` + algorithm +
+					`, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+			}}}
+			retry, err := auth.Refresh(res)
+			Expect(err).Should(BeNil())
+			Expect(retry).Should(BeTrue())
+
+			req, _ := http.NewRequest("GET", "http://www.example.org/dir/index.html", nil)
+			Expect(auth.Apply(req)).Should(BeNil())
+			params := parseChallenges(req.Header["Authorization"])[0].params
+			Expect(params["nc"]).Should(Equal("00000001"))
+			Expect(params["qop"]).Should(Equal("auth"))
+			return params["response"]
+		}
+
+		g.It("Should compute the RFC 7616 MD5 response", func() {
+			Expect(rfcResponse("MD5")).Should(Equal("8ca523f5e9506fed4657c9700eebdbec"))
+		})
+
+		g.It("Should compute the RFC 7616 SHA-256 response", func() {
+			Expect(rfcResponse("SHA-256")).Should(Equal("753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"))
+		})
+
+		for _, algorithm := range []string{"MD5", "SHA-256", "MD5-sess", "SHA-256-sess"} {
+			algorithm := algorithm
+			for _, qop := range []string{"auth", "auth-int", ""} {
+				qop := qop
+				g.It(fmt.Sprintf("Should authenticate with %s and qop %q", algorithm, qop), func() {
+					server := &digestServer{algorithm: algorithm, qop: qop}
+					ts := httptest.NewServer(server)
+					defer ts.Close()
+
+					res, err := Request{Method: "POST", Uri: ts.URL + "/foo?bar=baz", Body: "body", Auth: Digest("user", "pass")}.Do()
+
+					Expect(err).Should(BeNil())
+					Expect(res.StatusCode).Should(Equal(200))
+					str, _ := res.Body.ToString()
+					nc := "00000001"
+					if qop == "" {
+						nc = ""
+					}
+					Expect(str).Should(Equal(qop + " " + nc + " body"))
+					Expect(server.attempts).Should(Equal(2))
+				})
+			}
+		}
+
+		g.It("Should count nonce uses across requests of a client", func() {
+			server := &digestServer{algorithm: "SHA-256", qop: "auth"}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			c := NewClient(WithAuth(Digest("user", "pass")))
+
+			for i := 1; i <= 3; i++ {
+				res, err := c.Do(Request{Uri: ts.URL})
+				Expect(err).Should(BeNil())
+				str, _ := res.Body.ToString()
+				Expect(str).Should(Equal(fmt.Sprintf("auth %08x ", i)))
+			}
+			Expect(server.attempts).Should(Equal(4))
+		})
+
+		g.It("Should take a new nonce when the previous one is stale", func() {
+			server := &digestServer{algorithm: "MD5", qop: "auth", maxUses: 2}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			c := NewClient(WithAuth(Digest("user", "pass")))
+
+			var bodies []string
+			for i := 0; i < 3; i++ {
+				res, err := c.Do(Request{Method: "PUT", Uri: ts.URL, Body: "foo"})
+				Expect(err).Should(BeNil())
+				Expect(res.StatusCode).Should(Equal(200))
+				str, _ := res.Body.ToString()
+				bodies = append(bodies, str)
+			}
+			Expect(bodies).Should(Equal([]string{"auth 00000001 foo", "auth 00000002 foo", "auth 00000001 foo"}))
+			Expect(server.attempts).Should(Equal(5))
+		})
+
+		g.It("Should not retry wrong credentials", func() {
+			server := &digestServer{algorithm: "MD5", qop: "auth"}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			c := NewClient(WithAuth(Digest("user", "wrong")))
+
+			res, err := c.Do(Request{Uri: ts.URL})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(401))
+			Expect(server.attempts).Should(Equal(2))
+
+			res, err = c.Do(Request{Uri: ts.URL})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(401))
+			Expect(server.attempts).Should(Equal(3))
+		})
+
+		g.It("Should ignore unsupported challenges", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("WWW-Authenticate", `Digest realm="goreq", nonce="n", algorithm=SHA-512-256`)
+				w.WriteHeader(401)
+			}))
+			defer ts.Close()
+
+			res, err := Request{Uri: ts.URL, Auth: Digest("user", "pass")}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(401))
+		})
+	})
+}
@@ -0,0 +1,12 @@
+module github.com/franela/goreq
+
+go 1.13
+
+require (
+	github.com/andybalholm/brotli v1.0.0
+	github.com/franela/goblin v0.0.0-20210519012713-85d372ac71e2
+	github.com/klauspost/compress v1.10.3
+	github.com/onsi/gomega v1.4.3
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
+	golang.org/x/text v0.3.0
+)
@@ -1,23 +1,26 @@
 package goreq
 
 import (
-	"bufio"
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
-	"crypto/tls"
+	"context"
+	"encoding"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,51 +28,61 @@ type itimeout interface {
 	Timeout() bool
 }
 type Request struct {
-	headers             []headerTuple
-	cookies             []*http.Cookie
-	Method              string
-	Uri                 string
-	Body                interface{}
-	QueryString         interface{}
-	Timeout             time.Duration
-	ContentType         string
-	Accept              string
-	Host                string
-	UserAgent           string
-	Insecure            bool
-	MaxRedirects        int
-	RedirectHeaders     bool
-	Proxy               string
-	proxyConnectHeaders []headerTuple
-	Compression         *compression
-	BasicAuthUsername   string
-	BasicAuthPassword   string
-	CookieJar           http.CookieJar
-	ShowDebug           bool
-	OnBeforeRequest     func(goreq *Request, httpreq *http.Request)
+	headers                []headerTuple
+	cookies                []*http.Cookie
+	Context                context.Context
+	Method                 string
+	Uri                    string
+	Body                   interface{}
+	ContentLength          int64
+	QueryString            interface{}
+	OverrideQuery          bool
+	Timeout                time.Duration
+	ContentType            string
+	Accept                 string
+	Host                   string
+	UserAgent              string
+	Insecure               bool
+	MaxRedirects           int
+	RedirectHeaders        bool
+	Proxy                  string
+	proxyConnectHeaders    []headerTuple
+	Compression            *compression
+	BasicAuthUsername      string
+	BasicAuthPassword      string
+	Auth                   Authenticator
+	CookieJar              http.CookieJar
+	ShowDebug              bool
+	OnBeforeRequest        func(goreq *Request, httpreq *http.Request)
+	OnAfterResponse        func(goreq *Request, res *Response, err error)
+	Retry                  *RetryPolicy
+	ErrorOnStatus          bool
+	DisableCharsetDecoding bool
+	Middleware             []Middleware
+	resend                 bool
 }
 
 type compression struct {
-	writer          func(buffer io.Writer) (io.WriteCloser, error)
+	writer          func(buffer io.Writer, level int) (io.WriteCloser, error)
 	reader          func(buffer io.Reader) (io.ReadCloser, error)
+	level           int
+	minSize         int64
 	ContentEncoding string
 }
 
 type Response struct {
 	*http.Response
-	Uri  string
-	Body *Body
-	req  *http.Request
+	Uri    string
+	Body   *Body
+	req    *http.Request
+	cancel context.CancelFunc
 }
 
+// CancelRequest cancels the request context, aborting the request and any
+// pending read of its body.
 func (r Response) CancelRequest() {
-	cancelRequest(DefaultTransport, r.req)
-
-}
-
-func cancelRequest(transport interface{}, r *http.Request) {
-	if tp, ok := transport.(transportRequestCanceler); ok {
-		tp.CancelRequest(r)
+	if r.cancel != nil {
+		r.cancel()
 	}
 }
 
@@ -81,6 +94,10 @@ type headerTuple struct {
 type Body struct {
 	reader           io.ReadCloser
 	compressedReader io.ReadCloser
+	contentType      string
+	rawText          bool
+	// cancel releases the context of the request once the body is closed.
+	cancel context.CancelFunc
 }
 
 type Error struct {
@@ -88,8 +105,22 @@ type Error struct {
 	Err     error
 }
 
-type transportRequestCanceler interface {
-	CancelRequest(*http.Request)
+// newError wraps err, reporting whether it was caused by a timeout or an
+// exceeded context deadline.
+func newError(err error) *Error {
+	timeout := false
+	if t, ok := err.(itimeout); ok {
+		timeout = t.Timeout()
+	}
+	if ue, ok := err.(*url.Error); ok {
+		if t, ok := ue.Err.(itimeout); ok {
+			timeout = t.Timeout()
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		timeout = true
+	}
+	return &Error{timeout: timeout, Err: err}
 }
 
 func (e *Error) Timeout() bool {
@@ -100,14 +131,132 @@ func (e *Error) Error() string {
 	return e.Err.Error()
 }
 
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// statusErrorBodyLimit is the size of the body snippet kept by StatusError.
+const statusErrorBodyLimit = 4096
+
+// StatusError is returned, wrapped in an *Error, for non 2xx responses of
+// requests setting ErrorOnStatus. The Response is returned along with the
+// error and its Body can still be read in full.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body holds at most the first 4KB of the response body.
+	Body     []byte
+	Method   string
+	Uri      string
+	Response *Response
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.Uri, e.Status)
+	if len(e.Body) > 0 {
+		msg += ": " + string(e.Body)
+	}
+	return msg
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// pipeReader streams what write writes through a pipe. write only runs, in its
+// own goroutine, once the reader is first read, and closer, when not nil, is
+// closed along with the reader.
+type pipeReader struct {
+	write  func(w io.Writer) error
+	closer io.Closer
+	once   sync.Once
+	pr     *io.PipeReader
+}
+
+func (r *pipeReader) start() {
+	r.once.Do(func() {
+		pr, pw := io.Pipe()
+		r.pr = pr
+		go func() {
+			pw.CloseWithError(r.write(pw))
+		}()
+	})
+}
+
+func (r *pipeReader) Read(p []byte) (int, error) {
+	r.start()
+	if r.pr == nil {
+		// closed before being read
+		return 0, io.ErrClosedPipe
+	}
+	return r.pr.Read(p)
+}
+
+func (r *pipeReader) Close() error {
+	var started = true
+	r.once.Do(func() {
+		started = false
+	})
+	if started {
+		r.pr.Close()
+	}
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// checkStatus returns a *StatusError wrapped in an *Error when r sets
+// ErrorOnStatus and res isn't a 2xx response.
+func (r Request) checkStatus(res *Response) error {
+	if !r.ErrorOnStatus || res == nil || res.Response == nil || (res.StatusCode >= 200 && res.StatusCode < 300) {
+		return nil
+	}
+
+	statusErr := &StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Header:     res.Header,
+		Method:     r.Method,
+		Uri:        r.Uri,
+		Response:   res,
+	}
+	if res.Response.Request != nil {
+		statusErr.Uri = res.Response.Request.URL.String()
+	}
+
+	if res.Body != nil {
+		statusErr.Body, _ = ioutil.ReadAll(io.LimitReader(res.Body, statusErrorBodyLimit))
+		// put the snippet back so the whole body can still be read
+		if res.Body.compressedReader != nil {
+			res.Body.compressedReader = readCloser{io.MultiReader(bytes.NewReader(statusErr.Body), res.Body.compressedReader), res.Body.compressedReader}
+		} else {
+			res.Body.reader = readCloser{io.MultiReader(bytes.NewReader(statusErr.Body), res.Body.reader), res.Body.reader}
+		}
+	}
+	return &Error{Err: statusErr}
+}
+
 func (b *Body) Read(p []byte) (int, error) {
+	var n int
+	var err error
 	if b.compressedReader != nil {
-		return b.compressedReader.Read(p)
+		n, err = b.compressedReader.Read(p)
+	} else {
+		n, err = b.reader.Read(p)
 	}
-	return b.reader.Read(p)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return n, newError(err)
+	}
+	return n, err
 }
 
 func (b *Body) Close() error {
+	if b.cancel != nil {
+		defer b.cancel()
+	}
 	err := b.reader.Close()
 	if b.compressedReader != nil {
 		return b.compressedReader.Close()
@@ -119,38 +268,67 @@ func (b *Body) FromJsonTo(o interface{}) error {
 	return json.NewDecoder(b).Decode(o)
 }
 
-func (b *Body) ToString() (string, error) {
-	body, err := ioutil.ReadAll(b)
+func (b *Body) FromXmlTo(o interface{}) error {
+	return xml.NewDecoder(b).Decode(o)
+}
+
+// Decode decodes the body into o with the codec registered for the response
+// Content-Type. It returns an error wrapping ErrUnsupportedContentType when
+// there is none.
+func (b *Body) Decode(o interface{}) error {
+	codec, err := codecFor(b.contentType)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if codec.Decode == nil {
+		return fmt.Errorf("%w %q", ErrUnsupportedContentType, b.contentType)
 	}
-	return string(body), nil
+	return codec.Decode(b, o)
+}
+
+// ToString returns the body as a string. Like Text, it decodes the body to
+// UTF-8 according to its charset.
+func (b *Body) ToString() (string, error) {
+	return b.Text()
 }
 
 func Gzip() *compression {
 	reader := func(buffer io.Reader) (io.ReadCloser, error) {
 		return gzip.NewReader(buffer)
 	}
-	writer := func(buffer io.Writer) (io.WriteCloser, error) {
-		return gzip.NewWriter(buffer), nil
+	writer := func(buffer io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(buffer, level)
 	}
-	return &compression{writer: writer, reader: reader, ContentEncoding: "gzip"}
+	return &compression{writer: writer, reader: reader, level: DefaultCompression, ContentEncoding: "gzip"}
 }
 
 func Deflate() *compression {
 	reader := func(buffer io.Reader) (io.ReadCloser, error) {
 		return zlib.NewReader(buffer)
 	}
-	writer := func(buffer io.Writer) (io.WriteCloser, error) {
-		return zlib.NewWriter(buffer), nil
+	writer := func(buffer io.Writer, level int) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(buffer, level)
 	}
-	return &compression{writer: writer, reader: reader, ContentEncoding: "deflate"}
+	return &compression{writer: writer, reader: reader, level: DefaultCompression, ContentEncoding: "deflate"}
 }
 
 func Zlib() *compression {
 	return Deflate()
 }
 
+const formContentType = "application/x-www-form-urlencoded"
+
+type form struct {
+	values interface{}
+}
+
+// Form returns a request Body sending values as
+// application/x-www-form-urlencoded. values is either url.Values or a struct
+// encoded with the same `url` tags as QueryString.
+func Form(values interface{}) *form {
+	return &form{values: values}
+}
+
 func paramParse(query interface{}) (string, error) {
 	switch query.(type) {
 	case url.Values:
@@ -164,6 +342,18 @@ func paramParse(query interface{}) (string, error) {
 	}
 }
 
+// QueryMarshaler is implemented by types that encode themselves in a query
+// string or form. MarshalQuery adds the values for the field named name.
+type QueryMarshaler interface {
+	MarshalQuery(name string, values url.Values) error
+}
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	queryMarshalerType = reflect.TypeOf((*QueryMarshaler)(nil)).Elem()
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
 func paramParseStruct(v *url.Values, query interface{}) error {
 	var (
 		s = reflect.ValueOf(query)
@@ -178,9 +368,14 @@ func paramParseStruct(v *url.Values, query interface{}) error {
 		return errors.New("Can not parse QueryString.")
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		var name string
+	return paramParseFields(v, s, "", false)
+}
 
+// paramParseFields adds the fields of the struct s, naming them after prefix
+// with either a dot or brackets.
+func paramParseFields(v *url.Values, s reflect.Value, prefix string, brackets bool) error {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
 		field := s.Field(i)
 		typeField := t.Field(i)
 
@@ -195,59 +390,288 @@ func paramParseStruct(v *url.Values, query interface{}) error {
 
 		name, opts := parseTag(urlTag)
 
-		var omitEmpty, squash bool
-		omitEmpty = opts.Contains("omitempty")
-		squash = opts.Contains("squash")
-
-		if squash {
-			err := paramParseStruct(v, field.Interface())
+		if opts.Contains("squash") {
+			for field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface {
+				if field.IsNil() {
+					break
+				}
+				field = field.Elem()
+			}
+			if field.Kind() != reflect.Struct {
+				continue
+			}
+			err := paramParseFields(v, field, prefix, brackets)
 			if err != nil {
 				return err
 			}
 			continue
 		}
 
-		if urlTag == "" {
+		if name == "" {
 			name = strings.ToLower(typeField.Name)
 		}
 
-		if val := fmt.Sprintf("%v", field.Interface()); !(omitEmpty && len(val) == 0) {
-			v.Add(name, val)
+		err := paramParseValue(v, paramKey(prefix, name, brackets), field, opts, typeField.Tag.Get("layout"), brackets)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func paramKey(prefix string, name string, brackets bool) string {
+	switch {
+	case prefix == "":
+		return name
+	case brackets:
+		return prefix + "[" + name + "]"
+	default:
+		return prefix + "." + name
+	}
+}
+
+// paramParseValue adds the value val under key.
+//
+// Nil pointers are omitted under omitempty, slices and arrays add a value per
+// element (joined by commas with the comma option, or named key[] with the
+// brackets option), maps and structs add their entries as key.name (or
+// key[name] with the brackets option), and time.Time is formatted as RFC3339
+// unless the unix, unixmilli or unixnano option or a layout tag is given.
+// Types implementing QueryMarshaler or encoding.TextMarshaler encode
+// themselves.
+func paramParseValue(v *url.Values, key string, val reflect.Value, opts tagOptions, layout string, brackets bool) error {
+	omitEmpty := opts.Contains("omitempty")
+	brackets = brackets || opts.Contains("brackets")
+
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			if !omitEmpty {
+				v.Add(key, "")
+			}
+			return nil
+		}
+		if val.Kind() == reflect.Ptr && val.Type().Implements(queryMarshalerType) {
+			break
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Ptr && reflect.PtrTo(val.Type()).Implements(queryMarshalerType) {
+		// make the value addressable so pointer receivers are used
+		ptr := reflect.New(val.Type())
+		ptr.Elem().Set(val)
+		val = ptr
+	}
+	if m, ok := val.Interface().(QueryMarshaler); ok {
+		return m.MarshalQuery(key, *v)
+	}
+
+	if val.Type() == timeType {
+		t := val.Interface().(time.Time)
+		if !(omitEmpty && t.IsZero()) {
+			v.Add(key, formatTime(t, opts, layout))
+		}
+		return nil
+	}
+
+	if val.Type().Implements(textMarshalerType) {
+		text, err := val.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		if !(omitEmpty && len(text) == 0) {
+			v.Add(key, string(text))
+		}
+		return nil
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte is sent as a string
+			if !(omitEmpty && val.Len() == 0) {
+				v.Add(key, string(val.Bytes()))
+			}
+			return nil
 		}
+		var elements = &url.Values{}
+		for i := 0; i < val.Len(); i++ {
+			err := paramParseValue(elements, key, val.Index(i), opts, layout, brackets)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case len((*elements)[key]) == 0:
+		case opts.Contains("comma"):
+			v.Add(key, strings.Join((*elements)[key], ","))
+		case opts.Contains("brackets"):
+			(*v)[key+"[]"] = append((*v)[key+"[]"], (*elements)[key]...)
+		default:
+			(*v)[key] = append((*v)[key], (*elements)[key]...)
+		}
+		for name, values := range *elements {
+			if name != key {
+				// nested struct and map elements
+				(*v)[name] = append((*v)[name], values...)
+			}
+		}
+		return nil
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			err := paramParseValue(v, paramKey(key, fmt.Sprint(k.Interface()), brackets), val.MapIndex(k), opts, layout, brackets)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return paramParseFields(v, val, key, brackets)
+	}
+
+	if str := fmt.Sprintf("%v", val.Interface()); !(omitEmpty && len(str) == 0) {
+		v.Add(key, str)
 	}
 	return nil
 }
 
-func prepareRequestBody(b interface{}) (io.Reader, error) {
+func formatTime(t time.Time, opts tagOptions, layout string) string {
+	switch {
+	case opts.Contains("unix"):
+		return strconv.FormatInt(t.Unix(), 10)
+	case opts.Contains("unixmilli"):
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case opts.Contains("unixnano"):
+		return strconv.FormatInt(t.UnixNano(), 10)
+	case layout != "":
+		return t.Format(layout)
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// prepareRequestBody returns a reader for the body b and the content type
+// to use when the request doesn't set one.
+func prepareRequestBody(b interface{}, contentType string) (io.Reader, string, error) {
 	switch b.(type) {
 	case string:
 		// treat is as text
-		return strings.NewReader(b.(string)), nil
+		return strings.NewReader(b.(string)), "", nil
+	case *Multipart:
+		return b.(*Multipart).reader(), b.(*Multipart).ContentType(), nil
+	case url.Values, *url.Values:
+		if contentType != "" && !isFormContentType(contentType) {
+			return encodeBody(b, contentType)
+		}
+		param, _ := paramParse(b)
+		return strings.NewReader(param), formContentType, nil
+	case *form:
+		param, err := paramParse(b.(*form).values)
+		if err != nil {
+			return nil, "", err
+		}
+		return strings.NewReader(param), formContentType, nil
 	case io.Reader:
 		// treat is as text
-		return b.(io.Reader), nil
+		return b.(io.Reader), "", nil
 	case []byte:
 		//treat as byte array
-		return bytes.NewReader(b.([]byte)), nil
+		return bytes.NewReader(b.([]byte)), "", nil
 	case nil:
-		return nil, nil
+		return nil, "", nil
 	default:
-		// try to jsonify it
-		j, err := json.Marshal(b)
-		if err == nil {
-			return bytes.NewReader(j), nil
+		return encodeBody(b, contentType)
+	}
+}
+
+// encodeBody encodes b with the codec of contentType, or as JSON when it has
+// none.
+func encodeBody(b interface{}, contentType string) (io.Reader, string, error) {
+	encode := jsonCodec.Encode
+	if contentType != "" {
+		if codec, err := codecFor(contentType); err == nil && codec.Encode != nil {
+			encode = codec.Encode
 		}
-		return nil, err
 	}
+	j, err := encode(b)
+	if err == nil {
+		return bytes.NewReader(j), "", nil
+	}
+	return nil, "", err
+}
+
+// isFormContentType reports whether contentType is
+// application/x-www-form-urlencoded, whatever its parameters.
+func isFormContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == formContentType
+}
+
+// bodySize returns the length of the reader b prepared for body, declared
+// being the Request's ContentLength, or -1 when it isn't known.
+func bodySize(body interface{}, b io.Reader, declared int64) int64 {
+	switch v := b.(type) {
+	case *bytes.Buffer:
+		return int64(v.Len())
+	case *bytes.Reader:
+		return int64(v.Len())
+	case *strings.Reader:
+		return int64(v.Len())
+	}
+	if m, ok := body.(*Multipart); ok {
+		return m.size()
+	}
+	if declared > 0 {
+		return declared
+	}
+	if s, ok := b.(io.Seeker); ok {
+		current, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := s.Seek(0, io.SeekEnd)
+		if _, seekErr := s.Seek(current, io.SeekStart); err != nil || seekErr != nil {
+			return -1
+		}
+		return end - current
+	}
+	return -1
+}
+
+// rewinder returns the body sent for b and a function reading it again, for
+// files and other io.ReaderAt bodies of known size. Every reading has its own
+// offset, as net/http may still be reading the previous one when it asks for
+// the next. None of them closes b, which is returned as closer to be closed
+// once the request is done. Bodies held in memory are left to net/http, which
+// replays them itself.
+func rewinder(b io.Reader, size int64) (body io.Reader, replay func() (io.ReadCloser, error), closer io.Closer) {
+	switch b.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
+		return b, nil, nil
+	}
+	ra, ok := b.(io.ReaderAt)
+	s, seeks := b.(io.Seeker)
+	if !ok || !seeks || size <= 0 {
+		return b, nil, nil
+	}
+	start, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return b, nil, nil
+	}
+	closer, _ = b.(io.Closer)
+	return io.NewSectionReader(ra, start, size), func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(ra, start, size)), nil
+	}, closer
 }
 
 var DefaultDialer = &net.Dialer{Timeout: 1000 * time.Millisecond}
-var DefaultTransport http.RoundTripper = &http.Transport{Dial: DefaultDialer.Dial, Proxy: http.ProxyFromEnvironment}
+var DefaultTransport http.RoundTripper = &http.Transport{DialContext: DefaultDialer.DialContext, Proxy: http.ProxyFromEnvironment}
 var DefaultClient = &http.Client{Transport: DefaultTransport}
 
-var proxyTransport http.RoundTripper
-var proxyClient *http.Client
-
 func SetConnectTimeout(duration time.Duration) {
 	DefaultDialer.Timeout = duration
 }
@@ -259,6 +683,17 @@ func (r *Request) AddHeader(name string, value string) {
 	r.headers = append(r.headers, headerTuple{name: name, value: value})
 }
 
+// SetHeader replaces the values of the header name added with AddHeader.
+func (r *Request) SetHeader(name string, value string) {
+	headers := r.headers[:0:0]
+	for _, header := range r.headers {
+		if !strings.EqualFold(header.name, name) {
+			headers = append(headers, header)
+		}
+	}
+	r.headers = append(headers, headerTuple{name: name, value: value})
+}
+
 func (r Request) WithHeader(name string, value string) Request {
 	r.AddHeader(name, value)
 	return r
@@ -273,6 +708,13 @@ func (r Request) WithCookie(c *http.Cookie) Request {
 	return r
 }
 
+// WithContext returns a copy of r bound to ctx. Cancelling ctx aborts dialing,
+// redirects, the body upload and reads of the response body.
+func (r Request) WithContext(ctx context.Context) Request {
+	r.Context = ctx
+	return r
+}
+
 func (r *Request) AddProxyConnectHeader(name string, value string) {
 	if r.proxyConnectHeaders == nil {
 		r.proxyConnectHeaders = []headerTuple{}
@@ -285,150 +727,33 @@ func (r Request) WithProxyConnectHeader(name string, value string) Request {
 	return r
 }
 
-func (r Request) Do() (*Response, error) {
-	var client = DefaultClient
-	var transport = DefaultTransport
-	var resUri string
-	var redirectFailed bool
-
-	r.Method = valueOrDefault(r.Method, "GET")
-
-	// use a client with a cookie jar if necessary. We create a new client not
-	// to modify the default one.
-	if r.CookieJar != nil {
-		client = &http.Client{
-			Transport: transport,
-			Jar:       r.CookieJar,
-		}
-	}
-
-	if r.Proxy != "" {
-		proxyUrl, err := url.Parse(r.Proxy)
-		if err != nil {
-			// proxy address is in a wrong format
-			return nil, &Error{Err: err}
-		}
-
-		proxyHeader := make(http.Header)
-		if r.proxyConnectHeaders != nil {
-			for _, header := range r.proxyConnectHeaders {
-				proxyHeader.Add(header.name, header.value)
-			}
-		}
-
-		//If jar is specified new client needs to be built
-		if proxyTransport == nil || client.Jar != nil {
-			proxyTransport = &http.Transport{
-				Dial:               DefaultDialer.Dial,
-				Proxy:              http.ProxyURL(proxyUrl),
-				ProxyConnectHeader: proxyHeader,
-			}
-			proxyClient = &http.Client{Transport: proxyTransport, Jar: client.Jar}
-		} else if proxyTransport, ok := proxyTransport.(*http.Transport); ok {
-			proxyTransport.Proxy = http.ProxyURL(proxyUrl)
-			proxyTransport.ProxyConnectHeader = proxyHeader
-		}
-		transport = proxyTransport
-		client = proxyClient
-	}
-
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-
-		if len(via) > r.MaxRedirects {
-			redirectFailed = true
-			return errors.New("Error redirecting. MaxRedirects reached")
-		}
-
-		resUri = req.URL.String()
-
-		//By default Golang will not redirect request headers
-		// https://code.google.com/p/go/issues/detail?id=4800&q=request%20header
-		if r.RedirectHeaders {
-			for key, val := range via[0].Header {
-				req.Header[key] = val
-			}
-		}
-		return nil
-	}
-
-	if transport, ok := transport.(*http.Transport); ok {
-		if r.Insecure {
-			if transport.TLSClientConfig != nil {
-				transport.TLSClientConfig.InsecureSkipVerify = true
-			} else {
-				transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-			}
-		} else if transport.TLSClientConfig != nil {
-			// the default TLS client (when transport.TLSClientConfig==nil) is
-			// already set to verify, so do nothing in that case
-			transport.TLSClientConfig.InsecureSkipVerify = false
-		}
-	}
-
-	req, err := r.NewRequest()
-
-	if err != nil {
-		// we couldn't parse the URL.
-		return nil, &Error{Err: err}
-	}
-
-	timeout := false
-	if r.Timeout > 0 {
-		client.Timeout = r.Timeout
-	}
-
-	if r.ShowDebug {
-		dump, err := httputil.DumpRequest(req, true)
-		if err != nil {
-			log.Println(err)
-		}
-		log.Println(string(dump))
-	}
-
-	if r.OnBeforeRequest != nil {
-		r.OnBeforeRequest(&r, req)
-	}
-	res, err := client.Do(req)
-
-	if err != nil {
-		if !timeout {
-			if t, ok := err.(itimeout); ok {
-				timeout = t.Timeout()
-			}
-			if ue, ok := err.(*url.Error); ok {
-				if t, ok := ue.Err.(itimeout); ok {
-					timeout = t.Timeout()
-				}
-			}
-		}
-
-		var response *Response
-		//If redirect fails we still want to return response data
-		if redirectFailed {
-			if res != nil {
-				response = &Response{res, resUri, &Body{reader: res.Body}, req}
-			} else {
-				response = &Response{res, resUri, nil, req}
-			}
-		}
-
-		//If redirect fails and we haven't set a redirect count we shouldn't return an error
-		if redirectFailed && r.MaxRedirects == 0 {
-			return response, nil
-		}
-
-		return response, &Error{timeout: timeout, Err: err}
-	}
-
-	if r.Compression != nil && strings.Contains(res.Header.Get("Content-Encoding"), r.Compression.ContentEncoding) {
-		compressedReader, err := r.Compression.reader(res.Body)
-		if err != nil {
-			return nil, &Error{Err: err}
-		}
-		return &Response{res, resUri, &Body{reader: res.Body, compressedReader: compressedReader}, req}, nil
+// Resend asks for the request to be sent again once OnAfterResponse returns,
+// for instance after refreshing the credentials it uses. It has no effect
+// outside of OnAfterResponse. A request is resent at most 3 times per Do.
+//
+// Only requests whose body can be built again are resent: no body, a string,
+// a []byte, an encoded value or a Multipart made of fields and paths. Other
+// io.Reader bodies were consumed by the first request, so the original
+// response is returned instead.
+func (r *Request) Resend() {
+	r.resend = true
+}
+
+// replayable reports whether the request body can be built again for another
+// request.
+func (r Request) replayable() bool {
+	switch b := r.Body.(type) {
+	case *Multipart:
+		return b.replayable()
+	case io.Reader:
+		return false
 	}
+	return true
+}
 
-	return &Response{res, resUri, &Body{reader: res.Body}, req}, nil
+// Do sends the request using the default Client.
+func (r Request) Do() (*Response, error) {
+	return defaultClient.Do(r)
 }
 
 func (r Request) addHeaders(headersMap http.Header) {
@@ -444,56 +769,87 @@ func (r Request) addHeaders(headersMap http.Header) {
 }
 
 func (r Request) NewRequest() (*http.Request, error) {
+	req, _, err := r.newRequest(false)
+	return req, err
+}
 
-	b, e := prepareRequestBody(r.Body)
+// newRequest builds the request like NewRequest. With rewind, bodies read
+// from a file or another io.ReaderAt can be sent again, for redirects and
+// retries, and the returned closer must close them once the request is done.
+func (r Request) newRequest(rewind bool) (*http.Request, io.Closer, error) {
+	b, contentType, e := prepareRequestBody(r.Body, r.ContentType)
 	if e != nil {
 		// there was a problem marshaling the body
-		return nil, &Error{Err: e}
+		return nil, nil, &Error{Err: e}
 	}
+	r.ContentType = valueOrDefault(r.ContentType, contentType)
 
 	if r.QueryString != nil {
 		param, e := paramParse(r.QueryString)
 		if e != nil {
-			return nil, &Error{Err: e}
+			return nil, nil, &Error{Err: e}
+		}
+		r.Uri, e = mergeQuery(r.Uri, param, r.OverrideQuery)
+		if e != nil {
+			return nil, nil, &Error{Err: e}
 		}
-		r.Uri = r.Uri + "?" + param
 	}
 
-	var bodyReader io.Reader
-	if b != nil && r.Compression != nil {
-		buffer := bytes.NewBuffer([]byte{})
-		readBuffer := bufio.NewReader(b)
-		writer, err := r.Compression.writer(buffer)
-		if err != nil {
-			return nil, &Error{Err: err}
+	size := bodySize(r.Body, b, r.ContentLength)
+	var replay func() (io.ReadCloser, error)
+	var closer io.Closer
+	if m, ok := r.Body.(*Multipart); ok && m.replayable() {
+		replay = func() (io.ReadCloser, error) {
+			return m.reader(), nil
 		}
-		_, e = readBuffer.WriteTo(writer)
-		writer.Close()
+	} else if b != nil && rewind {
+		b, replay, closer = rewinder(b, size)
+	}
+
+	bodyReader := b
+	var getBody func() (io.ReadCloser, error)
+	var compressed bool
+	if b != nil && r.Compression != nil {
+		bodyReader, getBody, compressed, e = r.Compression.body(b, size, replay)
 		if e != nil {
-			return nil, &Error{Err: e}
+			return nil, nil, &Error{Err: e}
 		}
-		bodyReader = buffer
-	} else {
-		bodyReader = b
+	}
+	if !compressed && replay != nil {
+		getBody = replay
 	}
 
-	req, err := http.NewRequest(r.Method, r.Uri, bodyReader)
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.Uri, bodyReader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if getBody != nil {
+		req.GetBody = getBody
+	}
+	if !compressed && size > 0 && req.ContentLength == 0 {
+		req.ContentLength = size
 	}
+
 	// add headers to the request
 	req.Host = r.Host
 
 	r.addHeaders(req.Header)
-	if r.Compression != nil {
+	if compressed {
 		req.Header.Add("Content-Encoding", r.Compression.ContentEncoding)
-		req.Header.Add("Accept-Encoding", r.Compression.ContentEncoding)
 	}
 	if r.headers != nil {
 		for _, header := range r.headers {
 			req.Header.Add(header.name, header.value)
 		}
 	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+	}
 
 	//use basic auth if required
 	if r.BasicAuthUsername != "" {
@@ -503,7 +859,38 @@ func (r Request) NewRequest() (*http.Request, error) {
 	for _, c := range r.cookies {
 		req.AddCookie(c)
 	}
-	return req, nil
+	return req, closer, nil
+}
+
+// mergeQuery adds the encoded query param to the query of uri. Parameters
+// already in uri are kept, or replaced by the ones in param when override is
+// set. uri is left untouched when param is empty.
+func mergeQuery(uri string, param string, override bool) (string, error) {
+	if param == "" {
+		return uri, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case u.RawQuery == "":
+		u.RawQuery = param
+	case override:
+		query, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", err
+		}
+		params, _ := url.ParseQuery(param)
+		for name, values := range params {
+			query[name] = values
+		}
+		u.RawQuery = query.Encode()
+	default:
+		u.RawQuery = u.RawQuery + "&" + param
+	}
+	return u.String(), nil
 }
 
 // Return value if nonempty, def otherwise.
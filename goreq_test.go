@@ -1,17 +1,23 @@
 package goreq
 
 import (
+	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -20,11 +26,40 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// queryRange encodes itself as two query parameters.
+type queryRange struct {
+	from, to int
+}
+
+func (r *queryRange) MarshalQuery(name string, values url.Values) error {
+	if r.from > r.to {
+		return errors.New("invalid range")
+	}
+	values.Add(name+"_from", strconv.Itoa(r.from))
+	values.Add(name+"_to", strconv.Itoa(r.to))
+	return nil
+}
+
 type Query struct {
 	Limit int
 	Skip  int
 }
 
+// slowReader yields n bytes, one every 20ms.
+type slowReader struct {
+	n int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.n--
+	p[0] = 'a'
+	return 1, nil
+}
+
 func TestRequest(t *testing.T) {
 
 	query := Query{
@@ -46,10 +81,12 @@ func TestRequest(t *testing.T) {
 		g.Describe("General request methods", func() {
 			var ts *httptest.Server
 			var requestHeaders http.Header
+			var requestContentLength int64
 
 			g.Before(func() {
 				ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					requestHeaders = r.Header
+					requestContentLength = r.ContentLength
 					if (r.Method == "GET" || r.Method == "OPTIONS" || r.Method == "TRACE" || r.Method == "PATCH" || r.Method == "FOOBAR") && r.URL.Path == "/foo" {
 						w.WriteHeader(200)
 						fmt.Fprint(w, "bar")
@@ -90,6 +127,9 @@ func TestRequest(t *testing.T) {
 					if r.Method == "GET" && r.URL.Path == "/redirect_test/307" {
 						http.Redirect(w, r, "/getquery", 307)
 					}
+					if r.Method == "POST" && r.URL.Path == "/redirect_test/307" {
+						http.Redirect(w, r, "/", 307)
+					}
 					if r.Method == "GET" && r.URL.Path == "/redirect_test/destination" {
 						http.Redirect(w, r, ts.URL+"/destination", 301)
 					}
@@ -108,7 +148,7 @@ func TestRequest(t *testing.T) {
 						b := "{\"foo\":\"bar\",\"fuu\":\"baz\"}"
 						gw := gzip.NewWriter(w)
 						defer gw.Close()
-						if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+						if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 							w.Header().Add("Content-Encoding", "gzip")
 						}
 						w.WriteHeader(200)
@@ -119,7 +159,7 @@ func TestRequest(t *testing.T) {
 						b := "{\"foo\":\"bar\",\"fuu\":\"baz\"}"
 						gw := zlib.NewWriter(w)
 						defer gw.Close()
-						if strings.Contains(r.Header.Get("Content-Encoding"), "deflate") {
+						if strings.Contains(r.Header.Get("Accept-Encoding"), "deflate") {
 							w.Header().Add("Content-Encoding", "deflate")
 						}
 						w.WriteHeader(200)
@@ -473,6 +513,70 @@ func TestRequest(t *testing.T) {
 					Expect(res.Header.Get("Location")).Should(Equal(ts.URL + "/123"))
 				})
 
+				g.It("Should send url.Values as a form", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: valuesQuery}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("friend=jonas&friend=peter&name=marcos"))
+					Expect(requestHeaders.Get("Content-Type")).Should(Equal("application/x-www-form-urlencoded"))
+				})
+
+				g.It("Should encode url.Values with an explicit ContentType", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: url.Values{"a": {"b"}}, ContentType: "application/json"}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal(`{"a":["b"]}`))
+					Expect(requestHeaders.Get("Content-Type")).Should(Equal("application/json"))
+
+					res, err = Request{Method: "POST", Uri: ts.URL, Body: url.Values{"a": {"b"}}, ContentType: "application/x-www-form-urlencoded; charset=utf-8"}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ = res.Body.ToString()
+					Expect(str).Should(Equal("a=b"))
+				})
+
+				g.It("Should send a Form of a tagged struct", func() {
+					type Login struct {
+						User     string `url:"user"`
+						Password string `url:"pass"`
+						Remember string `url:"remember,omitempty"`
+						Token    string `url:"-"`
+					}
+					login := Login{User: "foo", Password: "b&r", Token: "secret"}
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: Form(login)}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("pass=b%26r&user=foo"))
+					Expect(requestHeaders.Get("Content-Type")).Should(Equal("application/x-www-form-urlencoded"))
+				})
+
+				g.It("Should send a Form of url.Values", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: Form(&valuesQuery)}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("friend=jonas&friend=peter&name=marcos"))
+				})
+
+				g.It("Should not override ContentType when sending a Form", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: Form(query), ContentType: "text/plain"}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("limit=3&skip=5"))
+					Expect(requestHeaders.Get("Content-Type")).Should(Equal("text/plain"))
+				})
+
+				g.It("Should return an error when a Form can't be encoded", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: Form("foo")}.Do()
+
+					Expect(res).Should(BeNil())
+					Expect(err).ShouldNot(BeNil())
+				})
+
 				g.It("Should return an error when body is not JSON encodable", func() {
 					res, err := Request{Method: "POST", Uri: ts.URL, Body: math.NaN()}.Do()
 
@@ -480,6 +584,96 @@ func TestRequest(t *testing.T) {
 					Expect(err).ShouldNot(BeNil())
 				})
 
+				g.It("Should send the Content-Length of bodies", func() {
+					for _, body := range []interface{}{"foo", []byte("foo"), strings.NewReader("foo"), map[string]string{"foo": "bar"}, valuesQuery, Form(query)} {
+						res, err := Request{Method: "POST", Uri: ts.URL, Body: body}.Do()
+
+						Expect(err).Should(BeNil())
+						str, _ := res.Body.ToString()
+						Expect(requestContentLength).Should(Equal(int64(len(str))))
+					}
+				})
+
+				g.It("Should send the Content-Length of seekable Readers", func() {
+					f, _ := ioutil.TempFile("", "goreq")
+					defer os.Remove(f.Name())
+					f.WriteString("foobar")
+					f.Seek(3, io.SeekStart)
+
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: f}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("bar"))
+					Expect(requestContentLength).Should(Equal(int64(3)))
+				})
+
+				g.It("Should send the declared ContentLength of other Readers", func() {
+					res, err := Request{Method: "POST", Uri: ts.URL, Body: io.MultiReader(strings.NewReader("foo"))}.Do()
+
+					Expect(err).Should(BeNil())
+					Expect(requestContentLength).Should(Equal(int64(-1)))
+
+					res, err = Request{Method: "POST", Uri: ts.URL, Body: io.MultiReader(strings.NewReader("foo")), ContentLength: 3}.Do()
+
+					Expect(err).Should(BeNil())
+					str, _ := res.Body.ToString()
+					Expect(str).Should(Equal("foo"))
+					Expect(requestContentLength).Should(Equal(int64(3)))
+				})
+
+				g.It("Should send bodies again on 307 redirects", func() {
+					for _, compression := range []*compression{nil, Gzip()} {
+						body := NewMultipart().AddField("foo", "bar")
+						res, err := Request{Method: "POST", Uri: ts.URL + "/redirect_test/307", Body: body, Compression: compression, MaxRedirects: 1}.Do()
+
+						Expect(err).Should(BeNil())
+						Expect(res.StatusCode).Should(Equal(201))
+						Expect(requestContentLength).ShouldNot(BeZero())
+					}
+				})
+
+				g.It("Should send files again on 307 redirects", func() {
+					for _, compression := range []*compression{nil, Gzip()} {
+						f, _ := ioutil.TempFile("", "goreq")
+						defer os.Remove(f.Name())
+						f.WriteString("foobar")
+						f.Seek(3, io.SeekStart)
+
+						res, err := Request{Method: "POST", Uri: ts.URL + "/redirect_test/307", Body: f, Compression: compression, MaxRedirects: 1}.Do()
+
+						Expect(err).Should(BeNil())
+						Expect(res.StatusCode).Should(Equal(201))
+						if compression == nil {
+							str, _ := res.Body.ToString()
+							Expect(str).Should(Equal("bar"))
+						}
+						// the file is closed along with the response
+						_, err = f.Stat()
+						Expect(err).Should(BeNil())
+						res.Body.Close()
+						_, err = f.Stat()
+						Expect(err).ShouldNot(BeNil())
+					}
+				})
+
+				g.It("Should send large files again while the first request still reads them", func() {
+					f, _ := ioutil.TempFile("", "goreq")
+					defer os.Remove(f.Name())
+					f.Write(bytes.Repeat([]byte("foobar"), 1<<20))
+					f.Close()
+
+					for i := 0; i < 5; i++ {
+						f, _ := os.Open(f.Name())
+						res, err := Request{Method: "POST", Uri: ts.URL + "/redirect_test/307", Body: f, MaxRedirects: 1}.Do()
+
+						Expect(err).Should(BeNil())
+						Expect(res.StatusCode).Should(Equal(201))
+						Expect(requestContentLength).Should(Equal(int64(6 << 20)))
+						res.Body.Close()
+					}
+				})
+
 				g.It("Should do a POST with querystring", func() {
 					bdy := []byte{'f', 'o', 'o'}
 					res, err := Request{
@@ -832,6 +1026,202 @@ func TestRequest(t *testing.T) {
 			})
 		})
 
+		g.Describe("Context", func() {
+			var ts *httptest.Server
+
+			g.Before(func() {
+				ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/partial" {
+						fmt.Fprintf(w, "Hello")
+						w.(http.Flusher).Flush()
+					}
+					if r.URL.Path == "/upload" {
+						ioutil.ReadAll(r.Body)
+					}
+					// block until the client goes away
+					<-r.Context().Done()
+				}))
+			})
+
+			g.After(func() {
+				ts.Close()
+			})
+
+			g.It("Should propagate the context to the http.Request", func() {
+				type key struct{}
+				ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "foo"))
+				defer cancel()
+
+				var value interface{}
+				hook := func(goreq *Request, httpreq *http.Request) {
+					value = httpreq.Context().Value(key{})
+					cancel()
+				}
+				Request{Uri: ts.URL, OnBeforeRequest: hook}.WithContext(ctx).Do()
+
+				Expect(value).Should(Equal("foo"))
+			})
+
+			g.It("Should release the request context when the body is closed", func() {
+				parent, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				res, err := Request{Uri: ts.URL + "/partial", Context: parent}.Do()
+				Expect(err).Should(BeNil())
+				Expect(res.req.Context().Err()).Should(BeNil())
+
+				res.Body.Close()
+				Expect(res.req.Context().Err()).Should(Equal(context.Canceled))
+				Expect(parent.Err()).Should(BeNil())
+			})
+
+			g.It("Should return a non timeout error when the context is canceled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(100*time.Millisecond, cancel)
+
+				res, err := Request{Uri: ts.URL, Context: ctx}.Do()
+
+				Expect(res).Should(BeNil())
+				Expect(err.(*Error).Timeout()).Should(BeFalse())
+				Expect(errors.Is(err, context.Canceled)).Should(BeTrue())
+			})
+
+			g.It("Should return a timeout error when the context deadline is exceeded", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				res, err := Request{Uri: ts.URL, Context: ctx}.Do()
+				elapsed := time.Since(start)
+
+				Expect(elapsed).Should(BeNumerically("<", 150*time.Millisecond))
+				Expect(res).Should(BeNil())
+				Expect(err.(*Error).Timeout()).Should(BeTrue())
+				Expect(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue())
+			})
+
+			g.It("Should cancel the body upload", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				res, err := Request{Method: "POST", Uri: ts.URL + "/upload", Body: &slowReader{n: 100}, Context: ctx}.Do()
+
+				Expect(res).Should(BeNil())
+				Expect(err.(*Error).Timeout()).Should(BeTrue())
+			})
+
+			g.It("Should cancel reads of the response body", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				res, err := Request{Uri: ts.URL + "/partial", Context: ctx}.Do()
+				Expect(err).Should(BeNil())
+				time.AfterFunc(100*time.Millisecond, cancel)
+				_, err = ioutil.ReadAll(res.Body)
+
+				Expect(err).Should(HaveOccurred())
+				Expect(err.(*Error).Timeout()).Should(BeFalse())
+				Expect(errors.Is(err, context.Canceled)).Should(BeTrue())
+			})
+
+			g.It("Should cancel reads of the response body when the deadline is exceeded", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				res, err := Request{Uri: ts.URL + "/partial", Context: ctx}.Do()
+				Expect(err).Should(BeNil())
+				_, err = ioutil.ReadAll(res.Body)
+
+				Expect(err).Should(HaveOccurred())
+				Expect(err.(*Error).Timeout()).Should(BeTrue())
+			})
+		})
+
+		g.Describe("QueryString", func() {
+			g.It("Should append to the query of the Uri", func() {
+				req, err := Request{Uri: "http://localhost/foo?a=1", QueryString: query}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.String()).Should(Equal("http://localhost/foo?a=1&limit=3&skip=5"))
+			})
+
+			g.It("Should keep existing parameters when appending", func() {
+				req, err := Request{Uri: "http://localhost/foo?limit=1", QueryString: query}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.Query()["limit"]).Should(Equal([]string{"1", "3"}))
+				Expect(req.URL.RawQuery).Should(Equal("limit=1&limit=3&skip=5"))
+			})
+
+			g.It("Should override existing parameters if asked to", func() {
+				req, err := Request{Uri: "http://localhost/foo?limit=1&a=1", QueryString: query, OverrideQuery: true}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.RawQuery).Should(Equal("a=1&limit=3&skip=5"))
+			})
+
+			g.It("Should override with url.Values", func() {
+				req, err := Request{Uri: "http://localhost/foo?name=jonas&a=1", QueryString: valuesQuery, OverrideQuery: true}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.RawQuery).Should(Equal("a=1&friend=jonas&friend=peter&name=marcos"))
+			})
+
+			g.It("Should set the query of a Uri without one when overriding", func() {
+				req, err := Request{Uri: "http://localhost/foo", QueryString: query, OverrideQuery: true}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.String()).Should(Equal("http://localhost/foo?limit=3&skip=5"))
+			})
+
+			g.It("Should preserve fragments", func() {
+				for _, override := range []bool{false, true} {
+					req, err := Request{Uri: "http://localhost/foo?a=1#bar", QueryString: query, OverrideQuery: override}.NewRequest()
+
+					Expect(err).Should(BeNil())
+					Expect(req.URL.String()).Should(Equal("http://localhost/foo?a=1&limit=3&skip=5#bar"))
+				}
+
+				req, err := Request{Uri: "http://localhost/foo#bar", QueryString: query}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.String()).Should(Equal("http://localhost/foo?limit=3&skip=5#bar"))
+			})
+
+			g.It("Should handle a Uri ending with ?", func() {
+				req, err := Request{Uri: "http://localhost/foo?", QueryString: query}.NewRequest()
+
+				Expect(err).Should(BeNil())
+				Expect(req.URL.String()).Should(Equal("http://localhost/foo?limit=3&skip=5"))
+			})
+
+			g.It("Should leave the Uri untouched when the query is empty", func() {
+				for _, uri := range []string{"http://localhost/foo", "http://localhost/foo?a=1", "http://localhost/foo?a=1#bar", "http://localhost/foo#bar"} {
+					for _, override := range []bool{false, true} {
+						req, err := Request{Uri: uri, QueryString: struct{}{}, OverrideQuery: override}.NewRequest()
+
+						Expect(err).Should(BeNil())
+						Expect(req.URL.String()).Should(Equal(uri))
+
+						req, err = Request{Uri: uri, QueryString: url.Values{}, OverrideQuery: override}.NewRequest()
+
+						Expect(err).Should(BeNil())
+						Expect(req.URL.String()).Should(Equal(uri))
+					}
+				}
+			})
+
+			g.It("Should return an error when the Uri can't be parsed", func() {
+				_, err := Request{Uri: ":", QueryString: query}.NewRequest()
+
+				Expect(err).ShouldNot(BeNil())
+			})
+
+			g.It("Should return an error when overriding a query that can't be parsed", func() {
+				_, err := Request{Uri: "http://localhost/foo?a=%zz", QueryString: query, OverrideQuery: true}.NewRequest()
+
+				Expect(err).ShouldNot(BeNil())
+			})
+		})
+
 		g.Describe("Misc", func() {
 			g.It("Should set default golang user agent when not explicitly passed", func() {
 				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -897,7 +1287,7 @@ func TestRequest(t *testing.T) {
 				req := Request{Uri: ts.URL, Host: "foobar.com"}
 				req.Do()
 			})
-			g.It("Should change transport TLS config if Request.Insecure is set", func() {
+			g.It("Should skip TLS verification if Request.Insecure is set", func() {
 				ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(200)
 				}))
@@ -908,11 +1298,24 @@ func TestRequest(t *testing.T) {
 					Uri:      ts.URL,
 					Host:     "foobar.com",
 				}
-				res, _ := req.Do()
+				res, err := req.Do()
 
-				Expect(DefaultClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify).Should(Equal(true))
+				Expect(err).Should(BeNil())
 				Expect(res.StatusCode).Should(Equal(200))
 			})
+			g.It("Should not change the shared transport TLS config if Request.Insecure is set", func() {
+				ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(200)
+				}))
+				defer ts.Close()
+
+				_, err := Request{Insecure: true, Uri: ts.URL}.Do()
+				Expect(err).Should(BeNil())
+
+				Expect(DefaultTransport.(*http.Transport).TLSClientConfig).Should(BeNil())
+				_, err = Request{Uri: ts.URL}.Do()
+				Expect(err).ShouldNot(BeNil())
+			})
 			g.It("Should work if a different transport is specified", func() {
 				ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(200)
@@ -928,7 +1331,7 @@ func TestRequest(t *testing.T) {
 				}
 				res, _ := req.Do()
 
-				Expect(DefaultClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify).Should(Equal(true))
+				Expect(DefaultTransport.(*http.Transport).TLSClientConfig).Should(BeNil())
 				Expect(res.StatusCode).Should(Equal(200))
 
 				DefaultTransport = currentTransport
@@ -966,6 +1369,144 @@ func TestRequest(t *testing.T) {
 			})
 		})
 
+		g.Describe("OnAfterResponse", func() {
+			var ts *httptest.Server
+			var attempts int
+
+			g.Before(func() {
+				ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					attempts++
+					if r.Header.Get("Authorization") != "Bearer fresh" {
+						w.WriteHeader(401)
+						return
+					}
+					if r.URL.Path == "/body" {
+						io.Copy(w, r.Body)
+						return
+					}
+					fmt.Fprint(w, r.Header["Authorization"])
+				}))
+			})
+
+			g.BeforeEach(func() {
+				attempts = 0
+			})
+
+			g.After(func() {
+				ts.Close()
+			})
+
+			g.It("Should be called with the response", func() {
+				var status int
+				hook := func(goreq *Request, res *Response, err error) {
+					Expect(err).Should(BeNil())
+					Expect(goreq.Uri).Should(Equal(ts.URL))
+					status = res.StatusCode
+				}
+
+				res, err := Request{Uri: ts.URL, OnAfterResponse: hook}.Do()
+
+				Expect(err).Should(BeNil())
+				Expect(res.StatusCode).Should(Equal(401))
+				Expect(status).Should(Equal(401))
+			})
+
+			g.It("Should be called with the error", func() {
+				var hookErr error
+				hook := func(goreq *Request, res *Response, err error) {
+					Expect(res).Should(BeNil())
+					hookErr = err
+				}
+
+				_, err := Request{Uri: "http://127.0.0.1:1", OnAfterResponse: hook}.Do()
+
+				Expect(err).ShouldNot(BeNil())
+				Expect(hookErr).Should(Equal(err))
+			})
+
+			g.It("Should resend the request when asked to", func() {
+				req := Request{Uri: ts.URL}.WithHeader("Authorization", "Bearer stale")
+				req.OnAfterResponse = func(goreq *Request, res *Response, err error) {
+					if res.StatusCode == 401 {
+						goreq.SetHeader("Authorization", "Bearer fresh")
+						goreq.Resend()
+					}
+				}
+
+				res, err := req.Do()
+
+				Expect(err).Should(BeNil())
+				Expect(res.StatusCode).Should(Equal(200))
+				str, _ := res.Body.ToString()
+				Expect(str).Should(Equal("[Bearer fresh]"))
+				Expect(attempts).Should(Equal(2))
+			})
+
+			g.It("Should resend bodies which can be sent again", func() {
+				for _, body := range []interface{}{"payload", []byte("payload"), NewMultipart().AddField("foo", "payload")} {
+					attempts = 0
+					req := Request{Method: "PUT", Uri: ts.URL + "/body", Body: body}
+					req.OnAfterResponse = func(goreq *Request, res *Response, err error) {
+						if res.StatusCode == 401 {
+							goreq.SetHeader("Authorization", "Bearer fresh")
+							goreq.Resend()
+						}
+					}
+
+					res, err := req.Do()
+
+					Expect(err).Should(BeNil())
+					Expect(res.StatusCode).Should(Equal(200))
+					str, _ := res.Body.ToString()
+					Expect(str).Should(ContainSubstring("payload"))
+					Expect(attempts).Should(Equal(2))
+				}
+			})
+
+			g.It("Should not resend Reader bodies", func() {
+				for _, body := range []io.Reader{bytes.NewReader([]byte("payload")), io.MultiReader(strings.NewReader("payload"))} {
+					attempts = 0
+					req := Request{Method: "PUT", Uri: ts.URL + "/body", Body: body}
+					req.OnAfterResponse = func(goreq *Request, res *Response, err error) {
+						goreq.SetHeader("Authorization", "Bearer fresh")
+						goreq.Resend()
+					}
+
+					res, err := req.Do()
+
+					Expect(err).Should(BeNil())
+					Expect(res.StatusCode).Should(Equal(401))
+					Expect(attempts).Should(Equal(1))
+				}
+			})
+
+			g.It("Should limit the number of resends", func() {
+				calls := 0
+				hook := func(goreq *Request, res *Response, err error) {
+					calls++
+					goreq.Resend()
+				}
+
+				res, err := Request{Uri: ts.URL, OnAfterResponse: hook}.Do()
+
+				Expect(err).Should(BeNil())
+				Expect(res.StatusCode).Should(Equal(401))
+				Expect(calls).Should(Equal(4))
+				Expect(attempts).Should(Equal(4))
+			})
+
+			g.It("Should use the client hook", func() {
+				calls := 0
+				c := NewClient(WithOnAfterResponse(func(goreq *Request, res *Response, err error) {
+					calls++
+				}))
+
+				c.Do(Request{Uri: ts.URL})
+
+				Expect(calls).Should(Equal(1))
+			})
+		})
+
 		g.Describe("Errors", func() {
 			var ts *httptest.Server
 
@@ -976,6 +1517,19 @@ func TestRequest(t *testing.T) {
 						w.WriteHeader(201)
 						io.Copy(w, r.Body)
 					}
+					if r.URL.Path == "/notfound" {
+						w.Header().Set("X-Request-Id", "42")
+						w.WriteHeader(404)
+						fmt.Fprint(w, "not found")
+					}
+					if r.URL.Path == "/large" {
+						w.WriteHeader(500)
+						fmt.Fprint(w, strings.Repeat("x", 10000))
+					}
+					if r.URL.Path == "/redirect" {
+						w.Header().Set("Location", "/notfound")
+						w.WriteHeader(302)
+					}
 				}))
 			})
 
@@ -998,6 +1552,60 @@ func TestRequest(t *testing.T) {
 				_, err := Request{Uri: "http://.localhost"}.Do()
 				Expect(err).ShouldNot(BeNil())
 			})
+			g.It("Should not return status errors by default", func() {
+				res, err := Request{Uri: ts.URL + "/notfound"}.Do()
+
+				Expect(err).Should(BeNil())
+				Expect(res.StatusCode).Should(Equal(404))
+			})
+			g.It("Should return a StatusError for non 2xx responses if asked to", func() {
+				res, err := Request{Uri: ts.URL + "/notfound", ErrorOnStatus: true}.Do()
+
+				Expect(err).ShouldNot(BeNil())
+				var statusErr *StatusError
+				Expect(errors.As(err, &statusErr)).Should(BeTrue())
+				Expect(statusErr.StatusCode).Should(Equal(404))
+				Expect(statusErr.Header.Get("X-Request-Id")).Should(Equal("42"))
+				Expect(string(statusErr.Body)).Should(Equal("not found"))
+				Expect(statusErr.Method).Should(Equal("GET"))
+				Expect(statusErr.Uri).Should(Equal(ts.URL + "/notfound"))
+				Expect(statusErr.Response).Should(Equal(res))
+				Expect(err.Error()).Should(Equal("GET " + ts.URL + "/notfound: 404 Not Found: not found"))
+				Expect(err.(*Error).Timeout()).Should(BeFalse())
+
+				str, _ := res.Body.ToString()
+				Expect(str).Should(Equal("not found"))
+			})
+			g.It("Should bound the StatusError body and keep the full response body", func() {
+				res, err := Request{Uri: ts.URL + "/large", ErrorOnStatus: true}.Do()
+
+				var statusErr *StatusError
+				Expect(errors.As(err, &statusErr)).Should(BeTrue())
+				Expect(statusErr.Body).Should(HaveLen(4096))
+				str, _ := res.Body.ToString()
+				Expect(str).Should(HaveLen(10000))
+			})
+			g.It("Should report the final URL after redirects", func() {
+				_, err := Request{Uri: ts.URL + "/redirect", ErrorOnStatus: true, MaxRedirects: 1}.Do()
+
+				var statusErr *StatusError
+				Expect(errors.As(err, &statusErr)).Should(BeTrue())
+				Expect(statusErr.Uri).Should(Equal(ts.URL + "/notfound"))
+			})
+			g.It("Should return a StatusError for unfollowed redirects", func() {
+				res, err := Request{Uri: ts.URL + "/redirect", ErrorOnStatus: true}.Do()
+
+				var statusErr *StatusError
+				Expect(errors.As(err, &statusErr)).Should(BeTrue())
+				Expect(statusErr.StatusCode).Should(Equal(302))
+				Expect(res.StatusCode).Should(Equal(302))
+			})
+			g.It("Should use the client ErrorOnStatus option", func() {
+				_, err := NewClient(WithErrorOnStatus(true)).Do(Request{Uri: ts.URL + "/notfound"})
+
+				var statusErr *StatusError
+				Expect(errors.As(err, &statusErr)).Should(BeTrue())
+			})
 		})
 
 		g.Describe("Proxy", func() {
@@ -1211,6 +1819,120 @@ func Test_paramParse(t *testing.T) {
 			Expect(err).Should(BeNil())
 			Expect(str).Should(Equal(result))
 		})
+		g.It("Should use the lowercased field name when the tag has no name", func() {
+			str, err := paramParse(struct {
+				Foo string `url:",omitempty"`
+			}{"bar"})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("foo=bar"))
+		})
+		g.It("Should repeat keys for slices and arrays", func() {
+			str, err := paramParse(struct {
+				Tags  []string `url:"tag"`
+				Ids   [2]int   `url:"id"`
+				Empty []string `url:"empty"`
+			}{Tags: []string{"a", "b"}, Ids: [2]int{1, 2}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("id=1&id=2&tag=a&tag=b"))
+		})
+		g.It("Should join slices with commas", func() {
+			str, err := paramParse(struct {
+				Tags []string `url:"tag,comma"`
+			}{Tags: []string{"a", "b"}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("tag=a%2Cb"))
+		})
+		g.It("Should name slices with brackets", func() {
+			str, err := paramParse(struct {
+				Tags []string `url:"tag,brackets"`
+			}{Tags: []string{"a", "b"}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("tag%5B%5D=a&tag%5B%5D=b"))
+		})
+		g.It("Should send []byte as a string", func() {
+			str, err := paramParse(struct {
+				Data []byte `url:"data"`
+			}{Data: []byte("foo")})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("data=foo"))
+		})
+		g.It("Should dereference pointers and omit nil ones under omitempty", func() {
+			foo := "foo"
+			limit := 0
+			str, err := paramParse(struct {
+				Foo   *string `url:"foo"`
+				Limit *int    `url:"limit,omitempty"`
+				Bar   *string `url:"bar,omitempty"`
+				Baz   *string `url:"baz"`
+			}{Foo: &foo, Limit: &limit})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("baz=&foo=foo&limit=0"))
+		})
+		g.It("Should format time.Time", func() {
+			since := time.Date(2017, 12, 4, 10, 30, 0, 0, time.UTC)
+			str, err := paramParse(struct {
+				Default   time.Time  `url:"default"`
+				Unix      time.Time  `url:"unix,unix"`
+				UnixMilli time.Time  `url:"unixmilli,unixmilli"`
+				Layout    time.Time  `url:"layout" layout:"2006-01-02"`
+				Pointer   *time.Time `url:"pointer,unix"`
+				Zero      time.Time  `url:"zero,omitempty"`
+			}{since, since, since, since, &since, time.Time{}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("default=2017-12-04T10%3A30%3A00Z&layout=2017-12-04&pointer=1512383400&unix=1512383400&unixmilli=1512383400000"))
+		})
+		g.It("Should prefix nested structs and maps with dots", func() {
+			type Address struct {
+				City string `url:"city"`
+				Zip  string `url:"zip,omitempty"`
+			}
+			str, err := paramParse(struct {
+				Name    string            `url:"name"`
+				Address Address           `url:"address"`
+				Home    *Address          `url:"home"`
+				Filter  map[string]string `url:"filter"`
+			}{Name: "foo", Address: Address{City: "London"}, Home: &Address{City: "Paris", Zip: "75001"}, Filter: map[string]string{"b": "2", "a": "1"}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("address.city=London&filter.a=1&filter.b=2&home.city=Paris&home.zip=75001&name=foo"))
+		})
+		g.It("Should prefix nested structs and maps with brackets", func() {
+			type Address struct {
+				City string `url:"city"`
+			}
+			type User struct {
+				Name    string  `url:"name"`
+				Address Address `url:"address"`
+			}
+			str, err := paramParse(struct {
+				User   User           `url:"user,brackets"`
+				Filter map[string]int `url:"filter,brackets"`
+			}{User: User{Name: "foo", Address: Address{City: "London"}}, Filter: map[string]int{"a": 1}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("filter%5Ba%5D=1&user%5Baddress%5D%5Bcity%5D=London&user%5Bname%5D=foo"))
+		})
+		g.It("Should use encoding.TextMarshaler", func() {
+			ip := net.ParseIP("127.0.0.1")
+			str, err := paramParse(struct {
+				IP  net.IP   `url:"ip"`
+				IPs []net.IP `url:"ips,comma"`
+			}{IP: ip, IPs: []net.IP{ip, ip}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("ip=127.0.0.1&ips=127.0.0.1%2C127.0.0.1"))
+		})
+		g.It("Should use QueryMarshaler", func() {
+			str, err := paramParse(struct {
+				Range  queryRange  `url:"range"`
+				Ranges *queryRange `url:"ranges"`
+			}{Range: queryRange{1, 5}, Ranges: &queryRange{2, 3}})
+			Expect(err).Should(BeNil())
+			Expect(str).Should(Equal("range_from=1&range_to=5&ranges_from=2&ranges_to=3"))
+		})
+		g.It("Should return QueryMarshaler errors", func() {
+			_, err := paramParse(struct {
+				Range queryRange `url:"range"`
+			}{Range: queryRange{5, 1}})
+			Expect(err).ShouldNot(BeNil())
+		})
 	})
 
 }
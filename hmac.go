@@ -0,0 +1,216 @@
+package goreq
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by HMACSigner.Verify.
+var (
+	ErrSignatureMissing  = errors.New("goreq: request signature missing")
+	ErrSignatureMismatch = errors.New("goreq: request signature mismatch")
+	ErrSignatureExpired  = errors.New("goreq: request signature timestamp out of range")
+)
+
+// HMACSigner is an Authenticator signing requests with an HMAC of some of
+// their components, and verifying such signatures with Verify.
+//
+// Components are signed in order, each one on its own "name: value" line.
+// They are either header names or one of:
+//
+//	@method     the request method
+//	@path       the escaped path
+//	@query      the raw query string
+//	@timestamp  the signing time, sent in TimestampHeader
+//	@digest     the base64 SHA-256 digest of the body
+//
+// The signature is sent in Header as
+//
+//	keyId="key",algorithm="hmac-sha256",components="@method @path",signature="base64"
+type HMACSigner struct {
+	Key   []byte
+	KeyID string
+	// Algorithm is hmac-sha256, the default, hmac-sha512 or hmac-sha1.
+	Algorithm string
+	// Header carries the signature, Signature by default.
+	Header string
+	// Components lists the signed components, @method, @path, @timestamp
+	// and @digest by default.
+	Components []string
+	// TimestampHeader carries the signing time as a unix timestamp,
+	// X-Signature-Timestamp by default.
+	TimestampHeader string
+	// MaxSkew is how far from the current time the timestamp of a verified
+	// request can be. It isn't checked if zero.
+	MaxSkew time.Duration
+
+	now func() time.Time
+}
+
+var defaultHMACComponents = []string{"@method", "@path", "@timestamp", "@digest"}
+
+func (s *HMACSigner) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *HMACSigner) algorithm() (string, func() hash.Hash, error) {
+	algorithm := valueOrDefault(s.Algorithm, "hmac-sha256")
+	switch strings.ToLower(algorithm) {
+	case "hmac-sha256":
+		return algorithm, sha256.New, nil
+	case "hmac-sha512":
+		return algorithm, sha512.New, nil
+	case "hmac-sha1":
+		return algorithm, sha1.New, nil
+	}
+	return "", nil, fmt.Errorf("goreq: unsupported HMAC algorithm %q", algorithm)
+}
+
+func (s *HMACSigner) header() string {
+	return valueOrDefault(s.Header, "Signature")
+}
+
+func (s *HMACSigner) timestampHeader() string {
+	return valueOrDefault(s.TimestampHeader, "X-Signature-Timestamp")
+}
+
+func (s *HMACSigner) components() []string {
+	if len(s.Components) == 0 {
+		return defaultHMACComponents
+	}
+	return s.Components
+}
+
+// sign returns the signature of req, whose body is read from body.
+func (s *HMACSigner) sign(req *http.Request, body func() ([]byte, error)) (string, error) {
+	_, newHash, err := s.algorithm()
+	if err != nil {
+		return "", err
+	}
+
+	var signed strings.Builder
+	for _, component := range s.components() {
+		var value string
+		switch strings.ToLower(component) {
+		case "@method":
+			value = req.Method
+		case "@path":
+			value = req.URL.EscapedPath()
+		case "@query":
+			value = req.URL.RawQuery
+		case "@timestamp":
+			value = req.Header.Get(s.timestampHeader())
+		case "@digest":
+			b, err := body()
+			if err != nil {
+				return "", err
+			}
+			sum := sha256.Sum256(b)
+			value = base64.StdEncoding.EncodeToString(sum[:])
+		default:
+			if strings.EqualFold(component, "host") {
+				value = requestHost(req)
+			} else {
+				value = strings.Join(req.Header[http.CanonicalHeaderKey(component)], ", ")
+			}
+		}
+		fmt.Fprintf(&signed, "%s: %s\n", strings.ToLower(component), value)
+	}
+
+	mac := hmac.New(newHash, s.Key)
+	mac.Write([]byte(signed.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *HMACSigner) Apply(req *http.Request) error {
+	algorithm, _, err := s.algorithm()
+	if err != nil {
+		return err
+	}
+	req.Header.Set(s.timestampHeader(), strconv.FormatInt(s.time().Unix(), 10))
+
+	signature, err := s.sign(req, func() ([]byte, error) {
+		if !rewindable(req) {
+			return nil, errors.New("goreq: the body digest needs a body that can be read again")
+		}
+		if req.GetBody == nil {
+			return nil, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	})
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(s.header(), fmt.Sprintf(`keyId="%s",algorithm="%s",components="%s",signature="%s"`,
+		quoteEscaper.Replace(s.KeyID), algorithm, strings.ToLower(strings.Join(s.components(), " ")), signature))
+	return nil
+}
+
+// Verify checks the signature of a request received by a server, returning
+// ErrSignatureMissing, ErrSignatureMismatch or ErrSignatureExpired when it
+// isn't valid. The request body is read and replaced, so it can still be read
+// afterwards.
+func (s *HMACSigner) Verify(req *http.Request) error {
+	challenges := parseChallenges([]string{"HMAC " + req.Header.Get(s.header())})
+	params := challenges[0].params
+	if params["signature"] == "" {
+		return ErrSignatureMissing
+	}
+	if params["keyid"] != s.KeyID || params["components"] != strings.ToLower(strings.Join(s.components(), " ")) {
+		return ErrSignatureMismatch
+	}
+	if algorithm, _, err := s.algorithm(); err != nil {
+		return err
+	} else if !strings.EqualFold(params["algorithm"], algorithm) {
+		return ErrSignatureMismatch
+	}
+
+	signature, err := s.sign(req, func() ([]byte, error) {
+		if req.Body == nil {
+			return nil, nil
+		}
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		return b, err
+	})
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(params["signature"])) {
+		return ErrSignatureMismatch
+	}
+
+	if s.MaxSkew > 0 {
+		timestamp, err := strconv.ParseInt(req.Header.Get(s.timestampHeader()), 10, 64)
+		if err != nil {
+			return ErrSignatureExpired
+		}
+		skew := s.time().Sub(time.Unix(timestamp, 0))
+		if skew > s.MaxSkew || skew < -s.MaxSkew {
+			return ErrSignatureExpired
+		}
+	}
+	return nil
+}
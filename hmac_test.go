@@ -0,0 +1,148 @@
+package goreq
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestHMACSigner(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("HMACSigner", func() {
+		var ts *httptest.Server
+		var verifier *HMACSigner
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := verifier.Verify(r); err != nil {
+					w.WriteHeader(401)
+					fmt.Fprint(w, err)
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				fmt.Fprintf(w, "%s", b)
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		g.It("Should sign requests verified by the same configuration", func() {
+			verifier = &HMACSigner{Key: []byte("secret"), KeyID: "service", MaxSkew: time.Minute}
+			signer := &HMACSigner{Key: []byte("secret"), KeyID: "service"}
+
+			res, err := Request{Method: "POST", Uri: ts.URL + "/foo?bar=baz", Body: "body", Auth: signer}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("body"))
+			Expect(res.StatusCode).Should(Equal(200))
+		})
+
+		g.It("Should compute a known signature", func() {
+			signer := &HMACSigner{
+				Key:        []byte("secret"),
+				KeyID:      "key",
+				Components: []string{"@method", "@path", "@query", "Content-Type", "@timestamp", "@digest"},
+				now:        func() time.Time { return time.Unix(1500000000, 0) },
+			}
+			req, _ := Request{Method: "POST", Uri: "http://example.com/foo?a=b", Body: "body", ContentType: "text/plain"}.NewRequest()
+
+			Expect(signer.Apply(req)).Should(BeNil())
+			Expect(req.Header.Get("X-Signature-Timestamp")).Should(Equal("1500000000"))
+			// echo -n "@method: POST
+			// @path: /foo
+			// @query: a=b
+			// content-type: text/plain
+			// @timestamp: 1500000000
+			// @digest: Iw2DWNyOiJC0xY3utikS7i8gNXrpKlzIYbmOaP4xrLU=
+			// " | openssl dgst -sha256 -hmac secret -binary | base64
+			Expect(req.Header.Get("Signature")).Should(Equal(`keyId="key",algorithm="hmac-sha256",` +
+				`components="@method @path @query content-type @timestamp @digest",signature="5qzPaz9m6liWGUlh2edHeFKv43NITQx65qiUQcV3vTE="`))
+		})
+
+		g.It("Should use the configured algorithm, headers and components", func() {
+			config := func() *HMACSigner {
+				return &HMACSigner{
+					Key:             []byte("secret"),
+					Algorithm:       "hmac-sha512",
+					Header:          "X-Sig",
+					TimestampHeader: "X-Time",
+					Components:      []string{"@method", "@query", "X-Tenant", "@timestamp"},
+				}
+			}
+			verifier = config()
+
+			res, err := Request{Uri: ts.URL + "/?a=b", Auth: config()}.WithHeader("X-Tenant", "acme").Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(res.Request.Header.Get("X-Sig")).Should(ContainSubstring(`algorithm="hmac-sha512"`))
+			Expect(res.Request.Header.Get("X-Time")).ShouldNot(BeEmpty())
+
+			req, _ := Request{Uri: ts.URL + "/?a=b"}.WithHeader("X-Tenant", "acme").NewRequest()
+			config().Apply(req)
+			req.Header.Set("X-Tenant", "other")
+			Expect(verifier.Verify(req)).Should(Equal(ErrSignatureMismatch))
+		})
+
+		g.It("Should reject tampered bodies, methods and paths", func() {
+			signer := &HMACSigner{Key: []byte("secret")}
+			signed := func() *http.Request {
+				req, _ := Request{Method: "POST", Uri: ts.URL + "/foo", Body: "body"}.NewRequest()
+				signer.Apply(req)
+				return req
+			}
+
+			req := signed()
+			Expect(signer.Verify(req)).Should(BeNil())
+
+			req = signed()
+			req.Body = ioutil.NopCloser(strings.NewReader("tampered"))
+			Expect(signer.Verify(req)).Should(Equal(ErrSignatureMismatch))
+
+			req = signed()
+			req.Method = "PUT"
+			Expect(signer.Verify(req)).Should(Equal(ErrSignatureMismatch))
+
+			req = signed()
+			req.URL.Path = "/bar"
+			Expect(signer.Verify(req)).Should(Equal(ErrSignatureMismatch))
+		})
+
+		g.It("Should reject wrong keys and missing signatures", func() {
+			req, _ := Request{Uri: ts.URL}.NewRequest()
+			Expect((&HMACSigner{Key: []byte("secret")}).Verify(req)).Should(Equal(ErrSignatureMissing))
+
+			(&HMACSigner{Key: []byte("wrong")}).Apply(req)
+			Expect((&HMACSigner{Key: []byte("secret")}).Verify(req)).Should(Equal(ErrSignatureMismatch))
+		})
+
+		g.It("Should reject old timestamps", func() {
+			signer := &HMACSigner{Key: []byte("secret"), now: func() time.Time { return time.Now().Add(-time.Hour) }}
+			req, _ := Request{Uri: ts.URL}.NewRequest()
+			signer.Apply(req)
+
+			Expect((&HMACSigner{Key: []byte("secret")}).Verify(req)).Should(BeNil())
+			Expect((&HMACSigner{Key: []byte("secret"), MaxSkew: time.Minute}).Verify(req)).Should(Equal(ErrSignatureExpired))
+		})
+
+		g.It("Should fail to sign streamed bodies", func() {
+			body := ioutil.NopCloser(strings.NewReader("body"))
+
+			_, err := Request{Method: "POST", Uri: ts.URL, Body: body, Auth: &HMACSigner{Key: []byte("secret")}}.Do()
+
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+}
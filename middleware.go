@@ -0,0 +1,33 @@
+package goreq
+
+import "net/http"
+
+// RoundTripFunc sends a single HTTP request and returns its response, like
+// http.RoundTripper.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the sending of a request. It is called for every request
+// actually sent over the wire, including redirects and retries, and may change
+// the request, inspect or replace the response, or return without calling
+// next at all.
+//
+// A middleware must not modify the request it is given; it should work on a
+// clone, as required from an http.RoundTripper.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// chain wraps transport with middleware. The first middleware is the
+// outermost one, so it sees the request first and the response last.
+func chain(transport http.RoundTripper, middleware ...[]Middleware) http.RoundTripper {
+	next := transport.RoundTrip
+	for i := len(middleware) - 1; i >= 0; i-- {
+		for j := len(middleware[i]) - 1; j >= 0; j-- {
+			next = middleware[i][j](next)
+		}
+	}
+	return RoundTripFunc(next)
+}
@@ -0,0 +1,151 @@
+package goreq
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestMiddleware(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Middleware", func() {
+		var ts *httptest.Server
+		var mu sync.Mutex
+		var attempts int
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				n := attempts
+				mu.Unlock()
+
+				switch r.URL.Path {
+				case "/redirect":
+					http.Redirect(w, r, "/", http.StatusFound)
+					return
+				case "/flaky":
+					if n < 2 {
+						w.WriteHeader(503)
+						return
+					}
+				}
+				fmt.Fprint(w, r.Header.Get("X-Trace"))
+			}))
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			attempts = 0
+			mu.Unlock()
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		trace := func(name string, calls *[]string) Middleware {
+			return func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					*calls = append(*calls, name+" "+req.URL.Path)
+					res, err := next(req)
+					*calls = append(*calls, name+" done")
+					return res, err
+				}
+			}
+		}
+
+		g.It("Should run client middleware around request middleware in order", func() {
+			var calls []string
+			c := NewClient(WithMiddleware(trace("c1", &calls), trace("c2", &calls)))
+
+			res, err := c.Do(Request{Uri: ts.URL + "/", Middleware: []Middleware{trace("r1", &calls)}})
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(calls).Should(Equal([]string{"c1 /", "c2 /", "r1 /", "r1 done", "c2 done", "c1 done"}))
+		})
+
+		g.It("Should run around every redirect", func() {
+			var calls []string
+
+			_, err := Request{Uri: ts.URL + "/redirect", MaxRedirects: 1, Middleware: []Middleware{trace("m", &calls)}}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(calls).Should(Equal([]string{"m /redirect", "m done", "m /", "m done"}))
+		})
+
+		g.It("Should run around every retry", func() {
+			var calls []string
+
+			res, err := Request{Uri: ts.URL + "/flaky", Retry: DefaultRetryPolicy(), Middleware: []Middleware{trace("m", &calls)}}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(calls).Should(Equal([]string{"m /flaky", "m done", "m /flaky", "m done"}))
+		})
+
+		g.It("Should let middleware change the request", func() {
+			setHeader := func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					req = req.Clone(req.Context())
+					req.Header.Set("X-Trace", "traced")
+					return next(req)
+				}
+			}
+
+			res, err := Request{Uri: ts.URL, Middleware: []Middleware{setHeader}}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("traced"))
+		})
+
+		g.It("Should let middleware short-circuit the request", func() {
+			mock := func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: 418,
+						Header:     make(http.Header),
+						Body:       ioutil.NopCloser(strings.NewReader("mocked")),
+						Request:    req,
+					}, nil
+				}
+			}
+
+			res, err := Request{Uri: ts.URL, Middleware: []Middleware{mock}}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(418))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("mocked"))
+			Expect(attempts).Should(Equal(0))
+		})
+
+		g.It("Should return middleware errors", func() {
+			fail := func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					return nil, errors.New("denied")
+				}
+			}
+
+			res, err := Request{Uri: ts.URL, Middleware: []Middleware{fail}}.Do()
+
+			Expect(res).Should(BeNil())
+			Expect(err).ShouldNot(BeNil())
+			Expect(err.Error()).Should(ContainSubstring("denied"))
+			Expect(attempts).Should(Equal(0))
+		})
+	})
+}
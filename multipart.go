@@ -0,0 +1,189 @@
+package goreq
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Multipart is a multipart/form-data request body made of fields and files.
+// It is streamed while the request is sent, so files are never loaded in
+// memory as a whole. When used as a Request Body the Content-Type, including
+// the boundary, is set automatically unless ContentType is given.
+type Multipart struct {
+	boundary string
+	parts    []MultipartFile
+}
+
+// MultipartFile describes a part of a Multipart body. The content is read
+// from Reader, or from the file at Path when Reader is nil.
+type MultipartFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string
+	Header      textproto.MIMEHeader
+	Path        string
+	Reader      io.Reader
+
+	field bool
+	value string
+}
+
+// NewMultipart returns an empty Multipart body with a random boundary.
+func NewMultipart() *Multipart {
+	return &Multipart{boundary: multipart.NewWriter(ioutil.Discard).Boundary()}
+}
+
+// AddField adds a form field.
+func (m *Multipart) AddField(name string, value string) *Multipart {
+	m.parts = append(m.parts, MultipartFile{FieldName: name, field: true, value: value})
+	return m
+}
+
+// AddFile adds the file at path. Its content type is guessed from the file
+// extension.
+func (m *Multipart) AddFile(fieldName string, path string) *Multipart {
+	return m.AddPart(MultipartFile{FieldName: fieldName, Path: path})
+}
+
+// AddFileReader adds a file named fileName whose content is read from r.
+func (m *Multipart) AddFileReader(fieldName string, fileName string, r io.Reader) *Multipart {
+	return m.AddPart(MultipartFile{FieldName: fieldName, FileName: fileName, Reader: r})
+}
+
+// AddPart adds a file part, allowing to set its content type and headers.
+func (m *Multipart) AddPart(part MultipartFile) *Multipart {
+	if part.FileName == "" && part.Path != "" {
+		part.FileName = filepath.Base(part.Path)
+	}
+	if part.ContentType == "" {
+		part.ContentType = mime.TypeByExtension(filepath.Ext(part.FileName))
+	}
+	if part.ContentType == "" {
+		part.ContentType = "application/octet-stream"
+	}
+	m.parts = append(m.parts, part)
+	return m
+}
+
+// ContentType returns the multipart/form-data content type, including the
+// boundary, of the body.
+func (m *Multipart) ContentType() string {
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// replayable reports whether the body can be produced more than once, which
+// is the case when every file is read from a path.
+func (m *Multipart) replayable() bool {
+	for _, part := range m.parts {
+		if part.Reader != nil {
+			return false
+		}
+	}
+	return true
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (p MultipartFile) header() textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	for name, values := range p.Header {
+		h[name] = values
+	}
+	if p.field {
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.FieldName)))
+		return h
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(p.FieldName), quoteEscaper.Replace(p.FileName)))
+	h.Set("Content-Type", p.ContentType)
+	return h
+}
+
+func (p MultipartFile) writeTo(w io.Writer) error {
+	if p.field {
+		_, err := io.WriteString(w, p.value)
+		return err
+	}
+	r := p.Reader
+	if r == nil {
+		f, err := os.Open(p.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+func (m *Multipart) writeTo(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+	for _, part := range m.parts {
+		pw, err := mw.CreatePart(part.header())
+		if err != nil {
+			return err
+		}
+		if err := part.writeTo(pw); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// size returns the length of the body, or -1 when it can't be known without
+// reading it, which is the case when a file is read from a Reader. The size of
+// files read from a path is taken from the file system.
+func (m *Multipart) size() int64 {
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return -1
+	}
+	var size int64
+	for _, part := range m.parts {
+		if _, err := mw.CreatePart(part.header()); err != nil {
+			return -1
+		}
+		switch {
+		case part.field:
+			size += int64(len(part.value))
+		case part.Reader == nil:
+			info, err := os.Stat(part.Path)
+			if err != nil {
+				return -1
+			}
+			size += info.Size()
+		default:
+			return -1
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return -1
+	}
+	return size + int64(counter)
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// reader returns a reader streaming the body. The body is only produced once
+// the reader is first read.
+func (m *Multipart) reader() io.ReadCloser {
+	return &pipeReader{write: m.writeTo}
+}
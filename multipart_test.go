@@ -0,0 +1,182 @@
+package goreq
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestMultipart(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Multipart", func() {
+		var ts *httptest.Server
+		var dir string
+		var mu sync.Mutex
+		var attempts int
+
+		g.Before(func() {
+			dir, _ = ioutil.TempDir("", "goreq")
+			ioutil.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo file"), 0644)
+
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				n := attempts
+				mu.Unlock()
+				if r.URL.Path == "/flaky" && n < 2 {
+					w.WriteHeader(503)
+					return
+				}
+				if err := r.ParseMultipartForm(1 << 20); err != nil {
+					w.WriteHeader(400)
+					fmt.Fprint(w, err)
+					return
+				}
+				var lines []string
+				for name, values := range r.MultipartForm.Value {
+					lines = append(lines, fmt.Sprintf("%s=%s", name, strings.Join(values, ",")))
+				}
+				for name, files := range r.MultipartForm.File {
+					for _, fh := range files {
+						f, _ := fh.Open()
+						b, _ := ioutil.ReadAll(f)
+						f.Close()
+						lines = append(lines, fmt.Sprintf("%s:%s:%s:%s:%s", name, fh.Filename, fh.Header.Get("Content-Type"), fh.Header.Get("X-Custom"), b))
+					}
+				}
+				sort.Strings(lines)
+				fmt.Fprint(w, strings.Join(lines, "\n"))
+			}))
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			attempts = 0
+			mu.Unlock()
+		})
+
+		g.After(func() {
+			ts.Close()
+			os.RemoveAll(dir)
+		})
+
+		g.It("Should set the multipart Content-Type with the boundary", func() {
+			body := NewMultipart().AddField("foo", "bar")
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: body}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			Expect(req.Header.Get("Content-Type")).Should(Equal(body.ContentType()))
+			Expect(req.Header.Get("Content-Type")).Should(HavePrefix("multipart/form-data; boundary="))
+		})
+
+		g.It("Should not override an explicit ContentType", func() {
+			body := NewMultipart().AddField("foo", "bar")
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: body, ContentType: "multipart/mixed; boundary=" + body.boundary}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			Expect(req.Header.Get("Content-Type")).Should(HavePrefix("multipart/mixed"))
+		})
+
+		g.It("Should send fields and files", func() {
+			body := NewMultipart().
+				AddField("foo", "bar").
+				AddField("foo", "baz").
+				AddField("name", "goreq").
+				AddFile("file", filepath.Join(dir, "foo.txt")).
+				AddFileReader("upload", "data.json", strings.NewReader(`{"foo":"bar"}`))
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: body}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal(strings.Join([]string{
+				"file:foo.txt:text/plain; charset=utf-8::foo file",
+				"foo=bar,baz",
+				"name=goreq",
+				`upload:data.json:application/json::{"foo":"bar"}`,
+			}, "\n")))
+		})
+
+		g.It("Should send parts with custom content type and headers", func() {
+			body := NewMultipart().AddPart(MultipartFile{
+				FieldName:   "file",
+				FileName:    "image",
+				ContentType: "image/png",
+				Header:      map[string][]string{"X-Custom": {"custom"}},
+				Reader:      strings.NewReader("png"),
+			})
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: body}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("file:image:image/png:custom:png"))
+		})
+
+		g.It("Should default to application/octet-stream", func() {
+			body := NewMultipart().AddFileReader("file", "data", strings.NewReader("data"))
+
+			res, err := Request{Method: "POST", Uri: ts.URL, Body: body}.Do()
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("file:data:application/octet-stream::data"))
+		})
+
+		g.It("Should stream the body", func() {
+			body := NewMultipart().AddField("foo", "bar").AddFile("file", filepath.Join(dir, "foo.txt"))
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: body}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			_, ok := req.Body.(*pipeReader)
+			Expect(ok).Should(BeTrue())
+			b, _ := ioutil.ReadAll(req.Body)
+			Expect(req.ContentLength).Should(Equal(int64(len(b))))
+			Expect(req.GetBody).ShouldNot(BeNil())
+		})
+
+		g.It("Should not know the length of bodies read from a Reader", func() {
+			body := NewMultipart().AddFileReader("file", "data", strings.NewReader("data"))
+			req, err := Request{Method: "POST", Uri: ts.URL, Body: body}.NewRequest()
+
+			Expect(err).Should(BeNil())
+			Expect(req.ContentLength).Should(Equal(int64(0)))
+			Expect(req.GetBody).Should(BeNil())
+		})
+
+		g.It("Should fail when a file can't be opened", func() {
+			body := NewMultipart().AddFile("file", filepath.Join(dir, "missing.txt"))
+
+			_, err := Request{Method: "POST", Uri: ts.URL, Body: body}.Do()
+
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		g.It("Should replay bodies made of fields and paths", func() {
+			body := NewMultipart().AddField("foo", "bar").AddFile("file", filepath.Join(dir, "foo.txt"))
+			policy := DefaultRetryPolicy()
+			policy.RetryNonIdempotent = true
+
+			res, err := Request{Method: "POST", Uri: ts.URL + "/flaky", Body: body, Retry: policy}.Do()
+
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("file:foo.txt:text/plain; charset=utf-8::foo file\nfoo=bar"))
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package goreq
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	// Expiry is when the token expires. It is zero if it never does.
+	Expiry time.Time `json:"-"`
+}
+
+// OAuth2Error is the error returned by an OAuth2 token endpoint.
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("oauth2: token request failed with status %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return "oauth2: " + e.Code
+	}
+	return "oauth2: " + e.Code + ": " + e.Description
+}
+
+// OAuth2 is an Authenticator sending the access tokens of an OAuth2 grant.
+//
+// Tokens are fetched from TokenURL when first needed, cached until they are
+// about to expire and renewed with their refresh token when the server gave
+// one. A token rejected with a 401 response is renewed and the request sent
+// again. An OAuth2 is safe for concurrent use and only fetches one token at a
+// time.
+//
+// An OAuth2 must be built with ClientCredentials, PasswordGrant or
+// RefreshToken, which set its grant, before its other fields are changed.
+type OAuth2 struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// AuthInBody sends the client credentials as form parameters instead of
+	// using basic auth.
+	AuthInBody bool
+	// ExpiryDelta is how long before their expiry tokens are renewed.
+	ExpiryDelta time.Duration
+	// Client sends the token requests. The default client is used if nil.
+	Client *Client
+
+	grant url.Values
+
+	mu    sync.Mutex
+	token *Token
+}
+
+const defaultExpiryDelta = 10 * time.Second
+
+// ErrOAuth2NoGrant is returned for an OAuth2 which wasn't built with one of
+// the grant constructors.
+var ErrOAuth2NoGrant = errors.New("goreq: OAuth2 has no grant, build it with ClientCredentials, PasswordGrant or RefreshToken")
+
+// ClientCredentials returns an OAuth2 authenticator using the client
+// credentials grant.
+func ClientCredentials(tokenURL string, clientID string, clientSecret string, scopes ...string) *OAuth2 {
+	return newOAuth2(tokenURL, clientID, clientSecret, scopes, url.Values{"grant_type": {"client_credentials"}})
+}
+
+// PasswordGrant returns an OAuth2 authenticator using the resource owner
+// password credentials grant.
+func PasswordGrant(tokenURL string, clientID string, clientSecret string, username string, password string, scopes ...string) *OAuth2 {
+	return newOAuth2(tokenURL, clientID, clientSecret, scopes, url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	})
+}
+
+// RefreshToken returns an OAuth2 authenticator getting its access tokens with
+// refreshToken.
+func RefreshToken(tokenURL string, clientID string, clientSecret string, refreshToken string) *OAuth2 {
+	o := newOAuth2(tokenURL, clientID, clientSecret, nil, url.Values{"grant_type": {"refresh_token"}})
+	o.token = &Token{RefreshToken: refreshToken}
+	return o
+}
+
+func newOAuth2(tokenURL string, clientID string, clientSecret string, scopes []string, grant url.Values) *OAuth2 {
+	return &OAuth2{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		ExpiryDelta:  defaultExpiryDelta,
+		grant:        grant,
+	}
+}
+
+func (o *OAuth2) valid(t *Token) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(o.ExpiryDelta).Before(t.Expiry)
+}
+
+// Token returns a valid access token, fetching a new one if needed.
+func (o *OAuth2) Token() (*Token, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.grant.Get("grant_type") == "" {
+		return nil, ErrOAuth2NoGrant
+	}
+	if o.valid(o.token) {
+		return o.token, nil
+	}
+
+	var token *Token
+	var err error
+	if o.token != nil && o.token.RefreshToken != "" {
+		token, err = o.fetch(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {o.token.RefreshToken}})
+		if err != nil && o.grant.Get("grant_type") != "refresh_token" {
+			// the refresh token may have expired, start over
+			token, err = o.fetch(o.grant)
+		}
+	} else {
+		token, err = o.fetch(o.grant)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken == "" && o.token != nil {
+		// the server may keep the refresh token unchanged
+		token.RefreshToken = o.token.RefreshToken
+	}
+	o.token = token
+	return token, nil
+}
+
+func (o *OAuth2) fetch(grant url.Values) (*Token, error) {
+	params := url.Values{}
+	for name, values := range grant {
+		params[name] = values
+	}
+	if len(o.Scopes) > 0 && params.Get("grant_type") != "refresh_token" {
+		params.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	req := Request{
+		Method: "POST",
+		Uri:    o.TokenURL,
+		Body:   params,
+		Accept: "application/json",
+		Auth:   BasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret)),
+	}
+	if o.AuthInBody {
+		params.Set("client_id", o.ClientID)
+		params.Set("client_secret", o.ClientSecret)
+		req.Auth = noAuth{}
+	}
+
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		oauthErr := &OAuth2Error{StatusCode: res.StatusCode}
+		res.Body.FromJsonTo(oauthErr)
+		return nil, oauthErr
+	}
+
+	var token Token
+	if err := res.Body.FromJsonTo(&token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, &OAuth2Error{StatusCode: res.StatusCode, Code: "invalid_response", Description: "no access_token in the response"}
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return &token, nil
+}
+
+func (o *OAuth2) Apply(req *http.Request) error {
+	token, err := o.Token()
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+// Refresh drops the rejected token so that a new one is fetched, unless it was
+// already renewed by another request.
+func (o *OAuth2) Refresh(res *http.Response) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token != nil && res.Request != nil && strings.HasSuffix(res.Request.Header.Get("Authorization"), " "+o.token.AccessToken) {
+		o.token = &Token{RefreshToken: o.token.RefreshToken}
+	}
+	return true, nil
+}
+
+// noAuth sends requests without credentials, even when the client has an
+// Authenticator.
+type noAuth struct{}
+
+func (noAuth) Apply(req *http.Request) error {
+	return nil
+}
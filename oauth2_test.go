@@ -0,0 +1,226 @@
+package goreq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestOAuth2(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("OAuth2", func() {
+		var ts, api *httptest.Server
+		var mu sync.Mutex
+		var issued int
+		var grants []string
+		var expiresIn int
+		var revoked string
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				r.ParseForm()
+				w.Header().Set("Content-Type", "application/json")
+
+				id, secret, ok := r.BasicAuth()
+				if ok {
+					id, _ = url.QueryUnescape(id)
+					secret, _ = url.QueryUnescape(secret)
+				} else {
+					id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+				}
+				if id != "client id" || secret != "secret" {
+					w.WriteHeader(401)
+					fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad client"}`)
+					return
+				}
+
+				grant := r.PostForm.Get("grant_type")
+				switch grant {
+				case "client_credentials":
+					grant += ":" + r.PostForm.Get("scope")
+				case "password":
+					if r.PostForm.Get("username") != "user" || r.PostForm.Get("password") != "pass" {
+						w.WriteHeader(400)
+						fmt.Fprint(w, `{"error":"invalid_grant"}`)
+						return
+					}
+				case "refresh_token":
+					if r.PostForm.Get("refresh_token") == revoked {
+						w.WriteHeader(400)
+						fmt.Fprint(w, `{"error":"invalid_grant"}`)
+						return
+					}
+					grant += ":" + r.PostForm.Get("refresh_token")
+				}
+				grants = append(grants, grant)
+				issued++
+
+				token := map[string]interface{}{
+					"access_token": fmt.Sprintf("token-%d", issued),
+					"token_type":   "bearer",
+				}
+				if expiresIn > 0 {
+					token["expires_in"] = expiresIn
+				}
+				if !strings.HasPrefix(grant, "client_credentials") {
+					token["refresh_token"] = fmt.Sprintf("refresh-%d", issued)
+				}
+				json.NewEncoder(w).Encode(token)
+			}))
+			api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				if auth == "Bearer token-1" && r.URL.Path == "/strict" {
+					w.WriteHeader(401)
+					return
+				}
+				fmt.Fprint(w, auth)
+			}))
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			issued = 0
+			grants = nil
+			expiresIn = 3600
+			revoked = ""
+			mu.Unlock()
+		})
+
+		g.After(func() {
+			ts.Close()
+			api.Close()
+		})
+
+		get := func(auth Authenticator, path string) string {
+			res, err := Request{Uri: api.URL + path, Auth: auth}.Do()
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			return str
+		}
+
+		g.It("Should get and cache a client credentials token", func() {
+			auth := ClientCredentials(ts.URL, "client id", "secret", "read", "write")
+
+			Expect(get(auth, "/")).Should(Equal("Bearer token-1"))
+			Expect(get(auth, "/")).Should(Equal("Bearer token-1"))
+			Expect(grants).Should(Equal([]string{"client_credentials:read write"}))
+		})
+
+		g.It("Should send the client credentials in the body if asked to", func() {
+			auth := ClientCredentials(ts.URL, "client id", "secret")
+			auth.AuthInBody = true
+
+			Expect(get(auth, "/")).Should(Equal("Bearer token-1"))
+		})
+
+		g.It("Should use the password grant and renew tokens with their refresh token", func() {
+			expiresIn = 5
+			auth := PasswordGrant(ts.URL, "client id", "secret", "user", "pass")
+			auth.ExpiryDelta = 10 * time.Second
+
+			Expect(get(auth, "/")).Should(Equal("Bearer token-1"))
+			Expect(get(auth, "/")).Should(Equal("Bearer token-2"))
+			Expect(grants).Should(Equal([]string{"password", "refresh_token:refresh-1"}))
+		})
+
+		g.It("Should start over when the refresh token is rejected", func() {
+			expiresIn = 5
+			revoked = "refresh-1"
+			auth := PasswordGrant(ts.URL, "client id", "secret", "user", "pass")
+			auth.ExpiryDelta = 10 * time.Second
+
+			get(auth, "/")
+			Expect(get(auth, "/")).Should(Equal("Bearer token-2"))
+			Expect(grants).Should(Equal([]string{"password", "password"}))
+		})
+
+		g.It("Should use a refresh token", func() {
+			auth := RefreshToken(ts.URL, "client id", "secret", "refresh-0")
+
+			Expect(get(auth, "/")).Should(Equal("Bearer token-1"))
+			Expect(grants).Should(Equal([]string{"refresh_token:refresh-0"}))
+		})
+
+		g.It("Should renew the token when it is rejected", func() {
+			auth := ClientCredentials(ts.URL, "client id", "secret")
+
+			Expect(get(auth, "/strict")).Should(Equal("Bearer token-2"))
+			Expect(get(auth, "/strict")).Should(Equal("Bearer token-2"))
+			Expect(issued).Should(Equal(2))
+		})
+
+		g.It("Should fetch a single token for concurrent requests", func() {
+			c := NewClient(WithAuth(ClientCredentials(ts.URL, "client id", "secret")))
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					res, err := c.Do(Request{Uri: api.URL})
+					if err == nil {
+						res.Body.Close()
+					}
+				}()
+			}
+			wg.Wait()
+
+			Expect(issued).Should(Equal(1))
+		})
+
+		g.It("Should return the token endpoint errors", func() {
+			auth := ClientCredentials(ts.URL, "client id", "wrong")
+
+			res, err := Request{Uri: api.URL, Auth: auth}.Do()
+
+			Expect(res).Should(BeNil())
+			var oauthErr *OAuth2Error
+			Expect(errors.As(err, &oauthErr)).Should(BeTrue())
+			Expect(oauthErr.StatusCode).Should(Equal(401))
+			Expect(oauthErr.Code).Should(Equal("invalid_client"))
+			Expect(oauthErr.Error()).Should(Equal("oauth2: invalid_client: bad client"))
+		})
+
+		g.It("Should fail without a grant", func() {
+			auth := &OAuth2{TokenURL: ts.URL, ClientID: "client id", ClientSecret: "secret"}
+			mu.Lock()
+			before := issued
+			mu.Unlock()
+
+			res, err := Request{Uri: api.URL, Auth: auth}.Do()
+
+			Expect(res).Should(BeNil())
+			Expect(errors.Is(err, ErrOAuth2NoGrant)).Should(BeTrue())
+			mu.Lock()
+			Expect(issued).Should(Equal(before))
+			mu.Unlock()
+		})
+
+		g.It("Should not authenticate token requests with itself", func() {
+			auth := ClientCredentials(ts.URL, "client id", "secret")
+			auth.AuthInBody = true
+			auth.Client = NewClient(WithAuth(auth))
+
+			res, err := auth.Client.Do(Request{Uri: api.URL})
+
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("Bearer token-1"))
+		})
+	})
+}
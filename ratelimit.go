@@ -0,0 +1,329 @@
+package goreq
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a number of requests allowed per period, refilled continuously. A
+// zero Rate allows any number of requests.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+	// Burst is the number of requests which can be sent at once after being
+	// idle. Requests is used if zero.
+	Burst int
+}
+
+func (r Rate) unlimited() bool {
+	return r.Requests <= 0 || r.Per <= 0
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Requests)
+}
+
+// RouteRate limits the requests matching Pattern.
+//
+// Pattern is matched with path.Match against the host and path of the
+// request, such as "api.example.com/v1/users/*", or against the path alone
+// when it starts with a "/". A pattern ending with a "/" matches every path
+// under it. It may be preceded by a method and a space, as in
+// "POST /v1/orders".
+type RouteRate struct {
+	Pattern string
+	Rate    Rate
+}
+
+func (r RouteRate) matches(req *http.Request) bool {
+	pattern := r.Pattern
+	if i := strings.Index(pattern, " "); i >= 0 {
+		if !strings.EqualFold(pattern[:i], req.Method) {
+			return false
+		}
+		pattern = strings.TrimSpace(pattern[i+1:])
+	}
+	target := req.URL.Path
+	if !strings.HasPrefix(pattern, "/") {
+		target = req.URL.Host + target
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(target, pattern)
+	}
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// RateLimitError is returned, wrapped in an *Error, for requests rejected by
+// a RateLimiter failing fast.
+type RateLimitError struct {
+	// Key is the host or route pattern whose limit was reached, or empty for
+	// the Global limit.
+	Key string
+	// RetryAfter is the time left before a request can be sent.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("rate limit reached, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("rate limit reached for %s, retry after %s", e.Key, e.RetryAfter)
+}
+
+// RateLimiter limits the rate of requests with token buckets: one for every
+// request, one for every host and one for every route.
+//
+// Requests wait for their turn, until their context is done, unless FailFast
+// is set. With AdaptToHeaders, the limiter also follows the quota announced by
+// servers in RateLimit-* or X-RateLimit-* headers, and the Retry-After header
+// of 429 responses.
+//
+// A RateLimiter is used through its Middleware, or set on a Client with
+// WithRateLimiter, and must not be copied after first use.
+type RateLimiter struct {
+	// Global limits all the requests.
+	Global Rate
+	// PerHost limits the requests to every host.
+	PerHost Rate
+	// Routes limit the requests matching their pattern. Only the first
+	// matching route applies.
+	Routes []RouteRate
+	// FailFast returns a *RateLimitError instead of waiting.
+	FailFast bool
+	// AdaptToHeaders holds requests to a host once the server announces its
+	// quota is exhausted, until it is reset.
+	AdaptToHeaders bool
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	quotas  map[string]*quota
+	now     func() time.Time
+}
+
+type bucketKey struct {
+	kind int
+	key  string
+}
+
+const (
+	globalBucket = iota
+	hostBucket
+	routeBucket
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait returns how long to wait for a token at now.
+func (b *bucket) wait(rate Rate, now time.Time) time.Duration {
+	perSecond := float64(rate.Requests) / rate.Per.Seconds()
+	b.tokens = math.Min(rate.burst(), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / perSecond * float64(time.Second)))
+}
+
+// quota is the state of a host's quota as announced by the server.
+type quota struct {
+	remaining int
+	reset     time.Time
+}
+
+// Middleware returns the middleware applying the rate limiter.
+func (l *RateLimiter) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if err := l.wait(req); err != nil {
+				return nil, err
+			}
+			res, err := next(req)
+			if err == nil && l.AdaptToHeaders {
+				l.update(req.URL.Host, res)
+			}
+			return res, err
+		}
+	}
+}
+
+func (l *RateLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// wait blocks until req can be sent, or returns why it can't.
+func (l *RateLimiter) wait(req *http.Request) error {
+	for {
+		delay, key := l.reserve(req)
+		if delay == 0 {
+			return nil
+		}
+		if l.FailFast {
+			return &RateLimitError{Key: key, RetryAfter: delay}
+		}
+		if err := sleep(req.Context(), delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token from every bucket applying to req if they all have
+// one. Otherwise it returns the longest delay before they do, and the key of
+// the bucket causing it.
+func (l *RateLimiter) reserve(req *http.Request) (time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+
+	var delay time.Duration
+	var delayKey string
+	if q, ok := l.quotas[req.URL.Host]; ok {
+		if !now.Before(q.reset) {
+			delete(l.quotas, req.URL.Host)
+		} else if q.remaining <= 0 {
+			delay, delayKey = q.reset.Sub(now), req.URL.Host
+		}
+	}
+
+	type limit struct {
+		bucket *bucket
+		rate   Rate
+		key    string
+	}
+	var limits []limit
+	add := func(kind int, key string, rate Rate) {
+		if rate.unlimited() {
+			return
+		}
+		if l.buckets == nil {
+			l.buckets = make(map[bucketKey]*bucket)
+		}
+		b, ok := l.buckets[bucketKey{kind, key}]
+		if !ok {
+			b = &bucket{tokens: rate.burst(), last: now}
+			l.buckets[bucketKey{kind, key}] = b
+		}
+		limits = append(limits, limit{b, rate, key})
+	}
+	add(globalBucket, "", l.Global)
+	add(hostBucket, req.URL.Host, l.PerHost)
+	for _, route := range l.Routes {
+		if route.matches(req) {
+			add(routeBucket, route.Pattern, route.Rate)
+			break
+		}
+	}
+
+	for _, limit := range limits {
+		if wait := limit.bucket.wait(limit.rate, now); wait > delay {
+			delay, delayKey = wait, limit.key
+		}
+	}
+	if delay > 0 {
+		return delay, delayKey
+	}
+
+	for _, limit := range limits {
+		limit.bucket.tokens--
+	}
+	if q, ok := l.quotas[req.URL.Host]; ok {
+		q.remaining--
+	}
+	return 0, ""
+}
+
+// update records the quota of host announced by res.
+func (l *RateLimiter) update(host string, res *http.Response) {
+	now := l.clock()
+	remaining, reset, ok := quotaFromHeaders(res.Header, now)
+	if res.StatusCode == http.StatusTooManyRequests {
+		if delay, found := retryAfter(res); found {
+			remaining, reset, ok = 0, now.Add(delay), true
+		}
+	}
+	if !ok || !now.Before(reset) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.quotas == nil {
+		l.quotas = make(map[string]*quota)
+	}
+	l.quotas[host] = &quota{remaining: remaining, reset: reset}
+}
+
+// quotaFromHeaders returns the remaining requests and the reset time announced
+// by the RateLimit-Remaining and RateLimit-Reset headers, their X-RateLimit-*
+// variants, or the RateLimit header. Resets are given in seconds, or as a unix
+// time by some X-RateLimit-Reset headers.
+func quotaFromHeaders(header http.Header, now time.Time) (int, time.Time, bool) {
+	remaining := firstHeader(header, "RateLimit-Remaining", "X-RateLimit-Remaining")
+	reset := firstHeader(header, "RateLimit-Reset", "X-RateLimit-Reset")
+	if remaining == "" && reset == "" {
+		// "limit=100, remaining=50, reset=5" or `"default";r=50;t=5`
+		for _, param := range strings.FieldsFunc(header.Get("RateLimit"), func(r rune) bool { return r == ',' || r == ';' }) {
+			name, value := param, ""
+			if i := strings.Index(param, "="); i >= 0 {
+				name, value = param[:i], param[i+1:]
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "remaining", "r":
+				remaining = strings.TrimSpace(value)
+			case "reset", "t":
+				reset = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	left, err := strconv.Atoi(remaining)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(reset, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, time.Time{}, false
+	}
+	if seconds > 1000000000 {
+		// too far away to be a number of seconds, it's a unix time
+		return left, time.Unix(seconds, 0), true
+	}
+	return left, now.Add(time.Duration(seconds) * time.Second), true
+}
+
+func firstHeader(header http.Header, names ...string) string {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// sleep waits for delay, or returns the error of ctx if it is done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package goreq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestRateLimiter(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("RateLimiter", func() {
+		var ts, other *httptest.Server
+		var mu sync.Mutex
+		var hits int
+		var now time.Time
+		var host string
+
+		g.Before(func() {
+			// The server replies with the headers and status given in the
+			// query string.
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits++
+				mu.Unlock()
+				status := 200
+				for name, values := range r.URL.Query() {
+					if name == "status" {
+						status, _ = strconv.Atoi(values[0])
+						continue
+					}
+					w.Header().Set(name, values[0])
+				}
+				w.WriteHeader(status)
+			})
+			ts = httptest.NewServer(handler)
+			other = httptest.NewServer(handler)
+			host = strings.TrimPrefix(ts.URL, "http://")
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			hits = 0
+			mu.Unlock()
+			now = time.Unix(0, 0)
+		})
+
+		g.After(func() {
+			ts.Close()
+			other.Close()
+		})
+
+		newLimiter := func(l *RateLimiter) *RateLimiter {
+			l.now = func() time.Time { return now }
+			return l
+		}
+
+		send := func(client *Client, uri string) error {
+			res, err := client.Do(Request{Uri: uri})
+			if res != nil {
+				res.Body.Close()
+			}
+			return err
+		}
+
+		rateLimited := func(err error) *RateLimitError {
+			var rateLimit *RateLimitError
+			Expect(errors.As(err, &rateLimit)).Should(BeTrue())
+			return rateLimit
+		}
+
+		g.It("Should limit all requests", func() {
+			l := newLimiter(&RateLimiter{Global: Rate{Requests: 2, Per: time.Minute}, FailFast: true})
+			client := NewClient(WithRateLimiter(l))
+
+			Expect(send(client, ts.URL)).Should(BeNil())
+			Expect(send(client, other.URL)).Should(BeNil())
+			rateLimit := rateLimited(send(client, ts.URL))
+			Expect(rateLimit.Key).Should(Equal(""))
+			Expect(rateLimit.RetryAfter).Should(Equal(30 * time.Second))
+
+			now = now.Add(30 * time.Second)
+			Expect(send(client, ts.URL)).Should(BeNil())
+		})
+
+		g.It("Should allow bursts", func() {
+			l := newLimiter(&RateLimiter{Global: Rate{Requests: 1, Per: time.Minute, Burst: 3}, FailFast: true})
+			client := NewClient(WithRateLimiter(l))
+
+			for i := 0; i < 3; i++ {
+				Expect(send(client, ts.URL)).Should(BeNil())
+			}
+			Expect(rateLimited(send(client, ts.URL)).RetryAfter).Should(Equal(time.Minute))
+		})
+
+		g.It("Should limit every host on its own", func() {
+			l := newLimiter(&RateLimiter{PerHost: Rate{Requests: 1, Per: time.Minute}, FailFast: true})
+			client := NewClient(WithRateLimiter(l))
+
+			Expect(send(client, ts.URL)).Should(BeNil())
+			Expect(send(client, other.URL)).Should(BeNil())
+			Expect(rateLimited(send(client, ts.URL)).Key).Should(Equal(host))
+		})
+
+		g.It("Should limit routes", func() {
+			l := newLimiter(&RateLimiter{Routes: []RouteRate{
+				{Pattern: "POST /orders", Rate: Rate{Requests: 1, Per: time.Minute}},
+				{Pattern: "/users/", Rate: Rate{Requests: 1, Per: time.Minute}},
+				{Pattern: host + "/items/*", Rate: Rate{Requests: 1, Per: time.Minute}},
+			}, FailFast: true})
+			client := NewClient(WithRateLimiter(l))
+
+			Expect(send(client, ts.URL+"/orders")).Should(BeNil())
+			Expect(send(client, ts.URL+"/orders")).Should(BeNil())
+			_, err := client.Do(Request{Method: "POST", Uri: ts.URL + "/orders"})
+			Expect(err).Should(BeNil())
+			_, err = client.Do(Request{Method: "POST", Uri: ts.URL + "/orders"})
+			Expect(rateLimited(err).Key).Should(Equal("POST /orders"))
+
+			Expect(send(client, ts.URL+"/users/1")).Should(BeNil())
+			Expect(rateLimited(send(client, other.URL+"/users/2")).Key).Should(Equal("/users/"))
+
+			Expect(send(client, ts.URL+"/items/1")).Should(BeNil())
+			Expect(send(client, other.URL+"/items/1")).Should(BeNil())
+			Expect(send(client, ts.URL+"/items/1/parts")).Should(BeNil())
+			Expect(rateLimited(send(client, ts.URL+"/items/2")).Key).Should(Equal(host + "/items/*"))
+		})
+
+		g.It("Should wait for its turn", func() {
+			l := &RateLimiter{Global: Rate{Requests: 10, Per: time.Second, Burst: 1}}
+			client := NewClient(WithRateLimiter(l))
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				Expect(send(client, ts.URL)).Should(BeNil())
+			}
+			Expect(time.Since(start)).Should(BeNumerically(">=", 190*time.Millisecond))
+		})
+
+		g.It("Should stop waiting when the context is done", func() {
+			l := &RateLimiter{Global: Rate{Requests: 1, Per: time.Minute}}
+			client := NewClient(WithRateLimiter(l))
+			send(client, ts.URL)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := client.Do(Request{Uri: ts.URL, Context: ctx})
+
+			Expect(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue())
+			mu.Lock()
+			Expect(hits).Should(Equal(1))
+			mu.Unlock()
+		})
+
+		g.It("Should not retry rate limited requests", func() {
+			l := newLimiter(&RateLimiter{Global: Rate{Requests: 1, Per: time.Minute}, FailFast: true})
+			client := NewClient(WithRateLimiter(l), WithRetryPolicy(DefaultRetryPolicy()))
+
+			rateLimited(send(client, ts.URL+"?status=503"))
+			mu.Lock()
+			Expect(hits).Should(Equal(1))
+			mu.Unlock()
+		})
+
+		g.Describe("AdaptToHeaders", func() {
+			quota := func(headers ...string) string {
+				query := url.Values{}
+				for i := 0; i < len(headers); i += 2 {
+					query.Set(headers[i], headers[i+1])
+				}
+				return ts.URL + "/?" + query.Encode()
+			}
+
+			g.It("Should hold requests once the quota is exhausted", func() {
+				l := newLimiter(&RateLimiter{AdaptToHeaders: true, FailFast: true})
+				client := NewClient(WithRateLimiter(l))
+
+				Expect(send(client, quota("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "30"))).Should(BeNil())
+				rateLimit := rateLimited(send(client, ts.URL))
+				Expect(rateLimit.Key).Should(Equal(host))
+				Expect(rateLimit.RetryAfter).Should(Equal(30 * time.Second))
+				Expect(send(client, other.URL)).Should(BeNil())
+
+				now = now.Add(30 * time.Second)
+				Expect(send(client, ts.URL)).Should(BeNil())
+			})
+
+			g.It("Should count the remaining requests", func() {
+				l := newLimiter(&RateLimiter{AdaptToHeaders: true, FailFast: true})
+				client := NewClient(WithRateLimiter(l))
+
+				Expect(send(client, quota("RateLimit-Remaining", "1", "RateLimit-Reset", "10"))).Should(BeNil())
+				Expect(send(client, ts.URL)).Should(BeNil())
+				rateLimited(send(client, ts.URL))
+			})
+
+			g.It("Should read the RateLimit header", func() {
+				l := newLimiter(&RateLimiter{AdaptToHeaders: true, FailFast: true})
+				client := NewClient(WithRateLimiter(l))
+
+				Expect(send(client, quota("RateLimit", "limit=10, remaining=0, reset=5"))).Should(BeNil())
+				Expect(rateLimited(send(client, ts.URL)).RetryAfter).Should(Equal(5 * time.Second))
+
+				now = now.Add(5 * time.Second)
+				Expect(send(client, quota("RateLimit", `"default";r=0;t=7`))).Should(BeNil())
+				Expect(rateLimited(send(client, ts.URL)).RetryAfter).Should(Equal(7 * time.Second))
+			})
+
+			g.It("Should read reset times given as a unix time", func() {
+				l := newLimiter(&RateLimiter{AdaptToHeaders: true, FailFast: true})
+				now = time.Unix(1600000000, 0)
+				client := NewClient(WithRateLimiter(l))
+
+				Expect(send(client, quota("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "1600000060"))).Should(BeNil())
+				Expect(rateLimited(send(client, ts.URL)).RetryAfter).Should(Equal(time.Minute))
+			})
+
+			g.It("Should hold requests after a 429 with Retry-After", func() {
+				l := newLimiter(&RateLimiter{AdaptToHeaders: true, FailFast: true})
+				client := NewClient(WithRateLimiter(l))
+
+				send(client, quota("status", "429", "Retry-After", "3"))
+				Expect(rateLimited(send(client, ts.URL)).RetryAfter).Should(Equal(3 * time.Second))
+			})
+
+			g.It("Should ignore the headers unless set", func() {
+				l := newLimiter(&RateLimiter{FailFast: true})
+				client := NewClient(WithRateLimiter(l))
+
+				Expect(send(client, quota("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "30"))).Should(BeNil())
+				Expect(send(client, ts.URL)).Should(BeNil())
+			})
+		})
+	})
+}
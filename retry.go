@@ -0,0 +1,232 @@
+package goreq
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff returns the delay to wait before the given retry, starting at 1.
+// last is the delay used before the previous retry, or zero for the first one.
+type Backoff func(retry int, last time.Duration) time.Duration
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(retry int, last time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff doubles the delay before every retry, starting at base
+// and never exceeding max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retry int, last time.Duration) time.Duration {
+		delay := base
+		for i := 1; i < retry && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// DecorrelatedJitterBackoff waits a random delay between base and three times
+// the previous delay, never exceeding max.
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return func(retry int, last time.Duration) time.Duration {
+		if last < base {
+			last = base
+		}
+		delay := base + time.Duration(rand.Int63n(int64(last*3-base)+1))
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// RetryPolicy describes when and how a failed request is sent again.
+//
+// Only idempotent methods are retried unless RetryNonIdempotent is set, and a
+// request is only retried when its body can be sent again: bodies given as a
+// string, []byte or JSON value always can, even when compressed, Multipart
+// bodies when made of fields and paths, and other io.Reader bodies only when
+// they are a *bytes.Buffer, *bytes.Reader or *strings.Reader, or implement
+// io.ReaderAt and io.Seeker, like an *os.File.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the request is sent, including the
+	// first one.
+	MaxAttempts int
+	// Backoff returns the delay before each retry. No delay is used if nil.
+	Backoff Backoff
+	// RetryStatus lists the response status codes that are retried.
+	RetryStatus []int
+	// RetryTimeouts retries requests failing with an error reporting Timeout().
+	RetryTimeouts bool
+	// RetryErrors retries requests failing with any other error, such as a
+	// refused connection.
+	RetryErrors bool
+	// RetryNonIdempotent allows retrying methods such as POST and PATCH.
+	RetryNonIdempotent bool
+	// IgnoreRetryAfter uses Backoff even when the response carries a
+	// Retry-After header.
+	IgnoreRetryAfter bool
+	// MaxRetryAfter is the longest Retry-After delay waited for, one minute
+	// if zero. A response asking to wait longer is returned instead of being
+	// retried.
+	MaxRetryAfter time.Duration
+}
+
+const defaultMaxRetryAfter = time.Minute
+
+// DefaultRetryPolicy returns a policy sending a request up to 3 times,
+// retrying errors and 429, 502, 503 and 504 responses with an exponential
+// backoff.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:   3,
+		Backoff:       ExponentialBackoff(100*time.Millisecond, 5*time.Second),
+		RetryStatus:   []int{429, 502, 503, 504},
+		RetryTimeouts: true,
+		RetryErrors:   true,
+	}
+}
+
+var errMaxRedirects = errors.New("Error redirecting. MaxRedirects reached")
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// rewindable reports whether req can be sent again once its body is consumed.
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind returns a copy of req with a fresh body, ready to be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// drain reads what is left of a small response body and closes it so the
+// connection can be reused.
+func drain(res *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+}
+
+func (p *RetryPolicy) retryable(req *http.Request, res *http.Response, err error) bool {
+	if !rewindable(req) {
+		// the body was consumed and can't be sent again
+		return false
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(req.Method) {
+		return false
+	}
+	if err != nil {
+		var circuitOpen *CircuitOpenError
+		var rateLimit *RateLimitError
+		if req.Context().Err() != nil || errors.Is(err, errMaxRedirects) || errors.As(err, &circuitOpen) || errors.As(err, &rateLimit) {
+			return false
+		}
+		if newError(err).Timeout() {
+			return p.RetryTimeouts
+		}
+		return p.RetryErrors
+	}
+	for _, status := range p.RetryStatus {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the Retry-After header of res,
+// given either in seconds or as an HTTP date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// delay returns the delay before the given retry, and false when the server
+// asks to wait longer than MaxRetryAfter.
+func (p *RetryPolicy) delay(retry int, last time.Duration, res *http.Response) (time.Duration, bool) {
+	if !p.IgnoreRetryAfter {
+		if delay, ok := retryAfter(res); ok {
+			max := p.MaxRetryAfter
+			if max <= 0 {
+				max = defaultMaxRetryAfter
+			}
+			return delay, delay <= max
+		}
+	}
+	if p.Backoff == nil {
+		return 0, true
+	}
+	return p.Backoff(retry, last), true
+}
+
+// do sends req with send until it succeeds, isn't retryable or MaxAttempts is
+// reached. Every retry gets a fresh copy of the request body.
+func (p *RetryPolicy) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	var last time.Duration
+	for attempt := 1; ; attempt++ {
+		res, err := send(req)
+		if attempt >= p.MaxAttempts || !p.retryable(req, res, err) {
+			return res, err
+		}
+
+		delay, ok := p.delay(attempt, last, res)
+		if !ok {
+			return res, err
+		}
+		last = delay
+		if res != nil {
+			drain(res)
+		}
+
+		if err := sleep(req.Context(), last); err != nil {
+			return nil, err
+		}
+
+		req, err = rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
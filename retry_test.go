@@ -0,0 +1,268 @@
+package goreq
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestRetry(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Backoff", func() {
+		g.It("Should wait a constant delay", func() {
+			b := ConstantBackoff(time.Second)
+			Expect(b(1, 0)).Should(Equal(time.Second))
+			Expect(b(5, time.Second)).Should(Equal(time.Second))
+		})
+
+		g.It("Should double the delay up to max", func() {
+			b := ExponentialBackoff(100*time.Millisecond, time.Second)
+			Expect(b(1, 0)).Should(Equal(100 * time.Millisecond))
+			Expect(b(2, 0)).Should(Equal(200 * time.Millisecond))
+			Expect(b(3, 0)).Should(Equal(400 * time.Millisecond))
+			Expect(b(5, 0)).Should(Equal(time.Second))
+			Expect(b(100, 0)).Should(Equal(time.Second))
+		})
+
+		g.It("Should wait a decorrelated random delay", func() {
+			b := DecorrelatedJitterBackoff(100*time.Millisecond, time.Second)
+			last := time.Duration(0)
+			for i := 1; i < 100; i++ {
+				delay := b(i, last)
+				Expect(delay).Should(BeNumerically(">=", 100*time.Millisecond))
+				Expect(delay).Should(BeNumerically("<=", time.Second))
+				if last > 0 {
+					Expect(delay).Should(BeNumerically("<=", 3*last))
+				}
+				last = delay
+			}
+		})
+	})
+
+	g.Describe("Retry-After", func() {
+		g.It("Should parse seconds", func() {
+			res := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+			delay, ok := retryAfter(res)
+			Expect(ok).Should(BeTrue())
+			Expect(delay).Should(Equal(2 * time.Second))
+		})
+
+		g.It("Should parse an HTTP date", func() {
+			date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+			res := &http.Response{Header: http.Header{"Retry-After": []string{date}}}
+			delay, ok := retryAfter(res)
+			Expect(ok).Should(BeTrue())
+			Expect(delay).Should(BeNumerically(">", 8*time.Second))
+			Expect(delay).Should(BeNumerically("<=", 10*time.Second))
+		})
+
+		g.It("Should ignore invalid values", func() {
+			res := &http.Response{Header: http.Header{"Retry-After": []string{"soon"}}}
+			_, ok := retryAfter(res)
+			Expect(ok).Should(BeFalse())
+		})
+	})
+
+	g.Describe("RetryPolicy", func() {
+		var ts *httptest.Server
+		var mu sync.Mutex
+		var attempts int
+		var bodies []string
+
+		g.Before(func() {
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				n := attempts
+				body := r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					body, _ = gzip.NewReader(r.Body)
+				}
+				b, _ := ioutil.ReadAll(body)
+				bodies = append(bodies, string(b))
+				mu.Unlock()
+
+				switch r.URL.Path {
+				case "/flaky":
+					if n < 3 {
+						w.WriteHeader(503)
+						return
+					}
+				case "/down":
+					w.WriteHeader(503)
+					return
+				case "/retry_after":
+					if n < 2 {
+						w.Header().Set("Retry-After", "1")
+						w.WriteHeader(429)
+						return
+					}
+				case "/slow":
+					if n < 2 {
+						time.Sleep(200 * time.Millisecond)
+					}
+				}
+				fmt.Fprint(w, n)
+			}))
+		})
+
+		g.BeforeEach(func() {
+			mu.Lock()
+			attempts = 0
+			bodies = nil
+			mu.Unlock()
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		g.It("Should retry retryable status codes", func() {
+			res, err := Request{Uri: ts.URL + "/flaky", Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("3"))
+		})
+
+		g.It("Should return the last response after MaxAttempts", func() {
+			policy := DefaultRetryPolicy()
+			policy.Backoff = ConstantBackoff(0)
+			policy.MaxAttempts = 4
+			res, err := Request{Uri: ts.URL + "/down", Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(503))
+			Expect(attempts).Should(Equal(4))
+		})
+
+		g.It("Should not retry status codes not listed", func() {
+			policy := DefaultRetryPolicy()
+			policy.RetryStatus = []int{500}
+			res, err := Request{Uri: ts.URL + "/flaky", Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(503))
+			Expect(attempts).Should(Equal(1))
+		})
+
+		g.It("Should not retry non idempotent methods by default", func() {
+			res, err := Request{Method: "POST", Uri: ts.URL + "/flaky", Body: "foo", Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(503))
+			Expect(attempts).Should(Equal(1))
+		})
+
+		g.It("Should retry non idempotent methods if allowed and replay the body", func() {
+			policy := DefaultRetryPolicy()
+			policy.RetryNonIdempotent = true
+			res, err := Request{Method: "POST", Uri: ts.URL + "/flaky", Body: map[string]string{"foo": "bar"}, Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(bodies).Should(Equal([]string{`{"foo":"bar"}`, `{"foo":"bar"}`, `{"foo":"bar"}`}))
+		})
+
+		g.It("Should replay compressed bodies", func() {
+			res, err := Request{Method: "PUT", Uri: ts.URL + "/flaky", Body: "foo", Compression: Gzip(), Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(bodies).Should(Equal([]string{"foo", "foo", "foo"}))
+		})
+
+		g.It("Should not retry bodies that can't be replayed", func() {
+			body := ioutil.NopCloser(strings.NewReader("foo"))
+			res, err := Request{Method: "PUT", Uri: ts.URL + "/flaky", Body: body, Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(503))
+			Expect(attempts).Should(Equal(1))
+		})
+
+		g.It("Should honor Retry-After", func() {
+			start := time.Now()
+			res, err := Request{Uri: ts.URL + "/retry_after", Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(time.Since(start)).Should(BeNumerically(">=", time.Second))
+		})
+
+		g.It("Should ignore Retry-After if asked to", func() {
+			policy := DefaultRetryPolicy()
+			policy.IgnoreRetryAfter = true
+			start := time.Now()
+			res, err := Request{Uri: ts.URL + "/retry_after", Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+		})
+
+		g.It("Should return the response when Retry-After is too long", func() {
+			policy := DefaultRetryPolicy()
+			policy.MaxRetryAfter = 500 * time.Millisecond
+			start := time.Now()
+			res, err := Request{Uri: ts.URL + "/retry_after", Retry: policy}.Do()
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(429))
+			Expect(time.Since(start)).Should(BeNumerically("<", 500*time.Millisecond))
+			mu.Lock()
+			Expect(attempts).Should(Equal(1))
+			mu.Unlock()
+		})
+
+		g.It("Should retry timeouts", func() {
+			res, err := Request{Uri: ts.URL + "/slow", Timeout: 100 * time.Millisecond, Retry: DefaultRetryPolicy()}.Do()
+			Expect(err).Should(BeNil())
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("2"))
+		})
+
+		g.It("Should not retry timeouts if not asked to", func() {
+			policy := DefaultRetryPolicy()
+			policy.RetryTimeouts = false
+			res, err := Request{Uri: ts.URL + "/slow", Timeout: 100 * time.Millisecond, Retry: policy}.Do()
+			Expect(res).Should(BeNil())
+			Expect(err.(*Error).Timeout()).Should(BeTrue())
+		})
+
+		g.It("Should retry connection errors", func() {
+			policy := DefaultRetryPolicy()
+			policy.Backoff = ConstantBackoff(0)
+			start := time.Now()
+			_, err := Request{Uri: "http://127.0.0.1:1", Retry: policy}.Do()
+			Expect(err).ShouldNot(BeNil())
+			Expect(err.(*Error).Timeout()).Should(BeFalse())
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+		})
+
+		g.It("Should stop waiting when the context is canceled", func() {
+			policy := DefaultRetryPolicy()
+			policy.Backoff = ConstantBackoff(time.Minute)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			res, err := Request{Uri: ts.URL + "/flaky", Retry: policy, Context: ctx}.Do()
+			Expect(res).Should(BeNil())
+			Expect(err.(*Error).Timeout()).Should(BeTrue())
+			Expect(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue())
+			Expect(attempts).Should(Equal(1))
+		})
+
+		g.It("Should use the client retry policy", func() {
+			c := NewClient(WithRetryPolicy(DefaultRetryPolicy()))
+			res, err := c.Do(Request{Uri: ts.URL + "/flaky"})
+			Expect(err).Should(BeNil())
+			Expect(res.StatusCode).Should(Equal(200))
+		})
+	})
+}
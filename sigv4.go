@@ -0,0 +1,234 @@
+package goreq
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// SigV4 is an Authenticator signing requests with AWS Signature Version 4.
+//
+// The signature covers the request as it is sent, including a compressed
+// body. Bodies that can't be read twice, such as plain io.Reader ones, are
+// sent as UNSIGNED-PAYLOAD. For the "s3" service the path is only escaped
+// once and the X-Amz-Content-Sha256 header is always sent, as S3 requires.
+type SigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is sent as X-Amz-Security-Token when using temporary
+	// credentials.
+	SessionToken string
+	Region       string
+	Service      string
+
+	now func() time.Time
+}
+
+func (s *SigV4) time() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *SigV4) Apply(req *http.Request) error {
+	t := s.time()
+
+	payloadHash, err := s.payloadHash(req)
+	if err != nil {
+		return err
+	}
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" || payloadHash == unsignedPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(req)
+	signature := s.signature(t, s.canonicalRequest(req, canonicalHeaders, signedHeaders, payloadHash))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.credential(t), signedHeaders, signature))
+	return nil
+}
+
+// Presign returns the URL of r with a signature valid for expires in its
+// query string, which lets anyone holding it send the request. S3 URLs leave
+// the payload unsigned.
+func (s *SigV4) Presign(r Request, expires time.Duration) (string, error) {
+	req, err := r.NewRequest()
+	if err != nil {
+		return "", err
+	}
+	t := s.time()
+
+	payloadHash := unsignedPayload
+	if s.Service != "s3" {
+		if payloadHash, err = s.payloadHash(req); err != nil {
+			return "", err
+		}
+	}
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.credential(t))
+	query.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", fmt.Sprint(int64(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	canonicalHeaders := "host:" + requestHost(req) + "\n"
+	signature := s.signature(t, s.canonicalRequest(req, canonicalHeaders, "host", payloadHash))
+	req.URL.RawQuery = canonicalQuery(req.URL) + "&X-Amz-Signature=" + signature
+	return req.URL.String(), nil
+}
+
+func (s *SigV4) credential(t time.Time) string {
+	return s.AccessKeyID + "/" + s.scope(t)
+}
+
+func (s *SigV4) scope(t time.Time) string {
+	return strings.Join([]string{t.Format("20060102"), s.Region, s.Service, "aws4_request"}, "/")
+}
+
+func (s *SigV4) payloadHash(req *http.Request) (string, error) {
+	if !rewindable(req) {
+		return unsignedPayload, nil
+	}
+	return hashBody(req, sha256.New)
+}
+
+// sigV4IgnoredHeaders are left unsigned as they may be changed on the way.
+var sigV4IgnoredHeaders = map[string]bool{
+	"Authorization":   true,
+	"User-Agent":      true,
+	"X-Amzn-Trace-Id": true,
+	"Expect":          true,
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+func (s *SigV4) canonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{"host": requestHost(req)}
+	for name, values := range req.Header {
+		if sigV4IgnoredHeaders[name] {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func (s *SigV4) canonicalRequest(req *http.Request, canonicalHeaders string, signedHeaders string, payloadHash string) string {
+	path := req.URL.EscapedPath()
+	if s.Service == "s3" {
+		// S3 object keys are signed escaped once, so send them that way
+		path = sigV4Escape(req.URL.Path, false)
+		req.URL.RawPath = path
+	} else {
+		path = sigV4Escape(path, false)
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(u *url.URL) string {
+	query, _ := url.ParseQuery(u.RawQuery)
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, sigV4Escape(name, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func (s *SigV4) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat),
+		s.scope(t),
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4Escape escapes every byte but the unreserved characters of RFC 3986,
+// and slashes unless encodeSlash is set.
+func sigV4Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
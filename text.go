@@ -0,0 +1,55 @@
+package goreq
+
+import (
+	"io/ioutil"
+	"mime"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// Text returns the body decoded to UTF-8 from the charset given in the
+// Content-Type of the response, or found in the <meta> tags of HTML documents.
+// Bodies without a known charset are returned as is, as are all bodies of
+// requests setting DisableCharsetDecoding.
+func (b *Body) Text() (string, error) {
+	body, err := ioutil.ReadAll(b)
+	if err != nil {
+		return "", err
+	}
+	if b.rawText {
+		return string(body), nil
+	}
+	if e := textEncoding(body, b.contentType); e != nil {
+		if decoded, err := e.NewDecoder().Bytes(body); err == nil {
+			return string(decoded), nil
+		}
+	}
+	return string(body), nil
+}
+
+// textEncoding returns the encoding of a body, or nil if it is UTF-8 or
+// unknown. It relies on golang.org/x/net/html/charset, which resolves charset
+// labels as the WHATWG Encoding Standard does and implements the HTML5
+// prescan of <meta> tags, rather than duplicating them on top of x/text.
+func textEncoding(body []byte, contentType string) encoding.Encoding {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	var e encoding.Encoding
+	var name string
+	if cs, ok := params["charset"]; ok {
+		e, name = charset.Lookup(cs)
+	} else if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		// sniff the BOM and <meta> tags like browsers do, but keep documents
+		// valid as UTF-8 unless their BOM says otherwise
+		var certain bool
+		e, name, certain = charset.DetermineEncoding(body, contentType)
+		if !certain && utf8.Valid(body) {
+			return nil
+		}
+	}
+	if name == "utf-8" {
+		return nil
+	}
+	return e
+}
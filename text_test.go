@@ -0,0 +1,82 @@
+package goreq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestText(t *testing.T) {
+	g := Goblin(t)
+
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Body.Text", func() {
+		var ts *httptest.Server
+
+		g.Before(func() {
+			bodies := map[string][]byte{
+				// "café" in ISO-8859-1
+				"latin1": []byte("caf\xe9"),
+				// "日本" in Shift_JIS
+				"sjis": []byte("\x93\xfa\x96\x7b"),
+				"meta": []byte(`<html><head><meta charset="iso-8859-1"></head><body>caf` + "\xe9</body></html>"),
+				"utf8": []byte("café"),
+				"bin":  []byte("\xff\xfe\x00\x01"),
+			}
+			ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+				w.Write(bodies[r.URL.Query().Get("body")])
+			}))
+		})
+
+		g.After(func() {
+			ts.Close()
+		})
+
+		text := func(r Request, contentType string, body string) string {
+			r.Uri = ts.URL
+			r.QueryString = url.Values{"type": {contentType}, "body": {body}}
+			res, err := r.Do()
+			Expect(err).Should(BeNil())
+			str, err := res.Body.Text()
+			Expect(err).Should(BeNil())
+			return str
+		}
+
+		g.It("Should decode the charset of the Content-Type", func() {
+			Expect(text(Request{}, "text/plain; charset=iso-8859-1", "latin1")).Should(Equal("café"))
+			Expect(text(Request{}, "text/plain; charset=Shift_JIS", "sjis")).Should(Equal("日本"))
+		})
+
+		g.It("Should decode ToString too", func() {
+			res, _ := Request{Uri: ts.URL, QueryString: url.Values{"type": {"text/plain; charset=latin1"}, "body": {"latin1"}}}.Do()
+			str, _ := res.Body.ToString()
+			Expect(str).Should(Equal("café"))
+		})
+
+		g.It("Should sniff the charset of HTML documents", func() {
+			Expect(text(Request{}, "text/html", "meta")).Should(Equal(`<html><head><meta charset="iso-8859-1"></head><body>café</body></html>`))
+		})
+
+		g.It("Should leave UTF-8 and unknown charsets as is", func() {
+			Expect(text(Request{}, "text/plain; charset=utf-8", "utf8")).Should(Equal("café"))
+			Expect(text(Request{}, "text/html", "utf8")).Should(Equal("café"))
+			Expect(text(Request{}, "text/plain; charset=unknown", "latin1")).Should(Equal("caf\xe9"))
+			Expect(text(Request{}, "application/octet-stream", "bin")).Should(Equal("\xff\xfe\x00\x01"))
+		})
+
+		g.It("Should not decode if disabled", func() {
+			Expect(text(Request{DisableCharsetDecoding: true}, "text/plain; charset=iso-8859-1", "latin1")).Should(Equal("caf\xe9"))
+
+			c := NewClient(WithDisableCharsetDecoding(true))
+			res, _ := c.Do(Request{Uri: ts.URL, QueryString: url.Values{"type": {"text/plain; charset=latin1"}, "body": {"latin1"}}})
+			str, _ := res.Body.Text()
+			Expect(str).Should(Equal("caf\xe9"))
+		})
+	})
+}